@@ -2,13 +2,15 @@ module gmap
 
 go 1.22.3
 
-require moul.io/banner v1.0.1
+require (
+	github.com/go-ping/ping v1.1.0
+	github.com/google/gopacket v1.1.19
+	golang.org/x/net v0.26.0
+	golang.org/x/sys v0.21.0
+	moul.io/banner v1.0.1
+)
 
 require (
-	github.com/go-ping/ping v1.1.0 // indirect
-	github.com/google/gopacket v1.1.19 // indirect
 	github.com/google/uuid v1.6.0 // indirect
-	golang.org/x/net v0.26.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
-	golang.org/x/sys v0.21.0 // indirect
 )
@@ -3,15 +3,18 @@ package main
 import (
 	"fmt"
 	"gmap/scanner"
+	"gmap/services"
 	"gmap/utils"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 )
 
 func main() {
 	// SIGINT handling
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
@@ -37,6 +40,16 @@ func main() {
 		return
 	}
 
+	// SCTP services live on their own set of ports
+	if !portsFlagSet && strings.HasPrefix(args.ScanType, "sctp") {
+		args.Ports = utils.CommonSctpPorts
+	}
+
+	// Protocol scans iterate protocol numbers instead of ports
+	if !portsFlagSet && args.ScanType == "proto" {
+		args.Ports = utils.AllProtocols
+	}
+
 	// Parse ports
 	ports, err := parsePorts(args.Ports)
 	if err != nil {
@@ -44,8 +57,8 @@ func main() {
 		return
 	}
 
-	// Parse target
-	target, err := parseTarget(args.Target)
+	// Parse targets
+	targets, err := parseTargets(args.Target)
 	if err != nil {
 		printHelp()
 		return
@@ -58,6 +71,85 @@ func main() {
 		return
 	}
 
+	// Validate protocol numbers on protocol scans
+	if scanType == "proto" {
+		if err := parseProtocols(ports); err != nil {
+			printHelp()
+			return
+		}
+	}
+
+	// Check for raw socket privileges and downgrade the scan if they are missing
+	if !scanner.HasRawSockets() {
+		switch scanType {
+		case "syn":
+			utils.PrintWarning("[!] CAP_NET_RAW is missing, SYN scan downgraded to TCP connect scan")
+			scanType = "tcp"
+		case "udp":
+			utils.PrintWarning("[!] CAP_NET_RAW is missing, ICMP replies cannot be captured so filtered UDP ports will show as open/filtered")
+		case "sctp-init", "sctp-cookie", "proto", "idle":
+			fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] %s scan requires raw sockets, run as root or grant CAP_NET_RAW", scanType)))
+			return
+		}
+
+		if args.OSDetection {
+			utils.PrintWarning("[!] CAP_NET_RAW is missing, OS detection disabled")
+			args.OSDetection = false
+		}
+
+		if args.Traceroute {
+			utils.PrintWarning("[!] CAP_NET_RAW is missing, traceroute disabled")
+			args.Traceroute = false
+		}
+
+		if !args.HostDiscovery {
+			utils.PrintWarning("[!] CAP_NET_RAW is missing, host discovery will use unprivileged ICMP or TCP connections and skip ACK, timestamp and address mask probes")
+		}
+	}
+
+	// Validate zombie on idle scans
+	var zombie string
+	var zombiePort int
+	if scanType == "idle" {
+		if zombie, zombiePort, err = parseZombie(args.Zombie); err != nil {
+			printHelp()
+			return
+		}
+	}
+
+	// Every probe after an idle scan would come from our own address, which the zombie is there to hide
+	if scanType == "idle" && (args.Versions || args.TLS || args.TLSVersions || args.Enumerate || args.OSDetection || args.Traceroute) {
+		utils.PrintWarning("[!] -sV, --tls, -sC, -O and --traceroute connect to the target from our own address, disabled on idle scans")
+		args.Versions, args.TLS, args.TLSVersions, args.Enumerate, args.OSDetection, args.Traceroute = false, false, false, false, false, false
+	}
+
+	// Validate version detection options
+	if args.Versions {
+		if err := parseVersionIntensity(args.VersionIntensity); err != nil {
+			printHelp()
+			return
+		}
+
+		if args.ServiceProbes != "" {
+			services.SetServiceProbesFile(args.ServiceProbes)
+		}
+	}
+
+	// Load the OUI list replacing the bundled sample
+	if args.OUIFile != "" {
+		if err := scanner.LoadOUIFile(args.OUIFile); err != nil {
+			fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not load OUI file: %v", err)))
+			return
+		}
+	}
+
+	// Parse host discovery probes
+	discovery, err := parseDiscovery(args)
+	if err != nil {
+		printHelp()
+		return
+	}
+
 	// Validate output and format if output flag is set
 	if outputFlagSet {
 		if err := parseFormat(args.Output, args.Format); err != nil {
@@ -68,35 +160,53 @@ func main() {
 
 	// Set the scan parameters
 	scanParams := utils.ScanParameters{
-		Target:  target,
-		Ports:   ports,
-		Timeout: args.Timeout,
+		Ports:      ports,
+		Timeout:    args.Timeout,
+		Delay:      args.ScanDelay,
+		Zombie:     zombie,
+		ZombiePort: zombiePort,
+		// OS detection probes need an open TCP port from the scan
+		OSDetection: args.OSDetection,
+		Traceroute:  args.Traceroute,
+		// Version probes run over TCP connections, no privileges needed
+		VersionDetection: args.Versions,
+		VersionIntensity: args.VersionIntensity,
+		// Enumerating versions needs the handshake to be inspected
+		TLSInspection: args.TLS || args.TLSVersions,
+		TLSVersions:   args.TLSVersions,
+		Enumeration:   args.Enumerate,
+		DNSDomain:     args.DNSDomain,
+		// Communities are tried in the order given
+		SNMPCommunities: strings.Split(args.SNMPCommunities, ","),
+		BannerLength:    args.BannerLength,
+		BannerTimeout:   args.BannerTimeout,
 	}
 
-	var hostDiscovery bool = args.HostDiscovery
+	// Idle scans must not reveal our address to the target, so the host is never pinged
+	var hostDiscovery bool = args.HostDiscovery || scanType == "idle"
 
-	var results []utils.Port
+	var hosts []utils.Host
 
-	if hostDiscovery || !hostDiscovery && scanner.HostUp(scanParams.Target, scanParams.Timeout) {
-		// Perform Scan
-		switch scanType {
-		// Perform UDP Scan
-		case "udp":
-			results = scanner.UdpScan(scanParams)
-		// Perform TCP Scan
-		case "tcp":
-			results = scanner.TcpScan(scanParams)
-		// Perform SYN Scan
-		case "syn":
-			results = scanner.SynScan(scanParams)
-		}
+	// Scan metadata for the exported results
+	run := utils.ScanRun{Args: strings.Join(os.Args, " "), Ports: ports, Targets: targets, Start: time.Now()}
+
+	if args.PingSweep {
+		// Only discover which hosts are alive
+		hosts = scanner.PingSweep(targets, discovery)
 	} else {
-		utils.PrintError("[ERROR] Host is not up")
+		run.ScanType = scanType
+		// Scan every target in turn
+		for _, target := range targets {
+			scanParams.Target = target
+			hosts = append(hosts, scanHost(scanParams, scanType, discovery, hostDiscovery))
+		}
 	}
 
+	run.End = time.Now()
+
 	// Export results if necessary
 	if args.Output != "" {
-		if err := utils.ExportResults(results, args.Output, args.Format); err != nil {
+		if err := utils.ExportResults(hosts, run, args.Output, args.Format); err != nil {
 			fmt.Println(err)
 			printHelp()
 		}
@@ -105,3 +215,103 @@ func main() {
 	// Succesfull exit
 	os.Exit(0)
 }
+
+// Check if a host is up and scan it
+func scanHost(scanParams utils.ScanParameters, scanType string, discovery utils.DiscoveryParameters, hostDiscovery bool) utils.Host {
+	var results []utils.Port
+	var host utils.Host
+
+	if hostDiscovery {
+		host = utils.Host{Address: scanParams.Target, Status: "up", Method: scanner.MethodUserSet}
+	} else {
+		host = scanner.DiscoverHost(scanParams.Target, discovery)
+	}
+
+	if host.Status != "up" {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Host %s is not up", scanParams.Target)))
+		return host
+	}
+
+	if !hostDiscovery {
+		utils.PrintSuccess(fmt.Sprintf("[!] Host %s is up, discovered through %s", scanParams.Target, host.Method))
+	}
+
+	// Hosts on the local segment are identified by their MAC address
+	if host.MAC != "" {
+		utils.PrintSuccess(fmt.Sprintf("[!] MAC address: %s (%s)", host.MAC, host.Vendor))
+	}
+
+	// Perform Scan
+	switch scanType {
+	// Perform UDP Scan
+	case "udp":
+		results = scanner.UdpScan(scanParams)
+	// Perform TCP Scan
+	case "tcp":
+		results = scanner.TcpScan(scanParams)
+	// Perform SYN Scan
+	case "syn":
+		results = scanner.SynScan(scanParams)
+	// Perform SCTP INIT Scan
+	case "sctp-init":
+		results = scanner.SctpScan(scanParams, scanner.SctpInit)
+	// Perform SCTP COOKIE-ECHO Scan
+	case "sctp-cookie":
+		results = scanner.SctpScan(scanParams, scanner.SctpCookieEcho)
+	// Perform IP protocol Scan
+	case "proto":
+		results = scanner.ProtocolScan(scanParams)
+	// Perform Idle Scan
+	case "idle":
+		results = scanner.IdleScan(scanParams)
+	}
+
+	// Probe open TCP ports for their versions
+	if scanParams.VersionDetection && (scanType == "tcp" || scanType == "syn") {
+		results = services.DetectVersions(scanParams, results)
+	}
+
+	// Handshake with open TCP ports to find the ones speaking TLS
+	if scanParams.TLSInspection && (scanType == "tcp" || scanType == "syn") {
+		results = services.InspectPorts(scanParams, results)
+	}
+
+	// Enumerate the services found on open TCP and UDP ports
+	if scanParams.Enumeration {
+		switch scanType {
+		case "tcp", "syn":
+			results = services.Enumerate(scanParams, results, "tcp")
+		case "udp":
+			results = services.Enumerate(scanParams, results, "udp")
+		}
+	}
+
+	host.Ports = results
+
+	// Only SYN scans capture the SYN/ACKs the guess relies on
+	if host.OSGuess = scanner.GuessOS(results); host.OSGuess != nil {
+		utils.PrintSuccess(fmt.Sprintf("[+] OS guess: %s (%d%% confidence)", host.OSGuess.Family, host.OSGuess.Confidence))
+	}
+
+	if scanParams.OSDetection {
+		// Only TCP scans tell which TCP ports are open and closed
+		var tcpPorts []utils.Port
+		if scanType == "tcp" || scanType == "syn" {
+			tcpPorts = results
+		}
+
+		host.OSMatches = scanner.OSDetect(scanParams.Target, tcpPorts, scanParams.Timeout)
+	}
+
+	if scanParams.Traceroute {
+		// Trace through an open port so the target answers, UDP scans trace with UDP probes
+		var tracePorts []utils.Port
+		if scanType == "tcp" || scanType == "syn" || scanType == "udp" {
+			tracePorts = results
+		}
+
+		host.Hops = scanner.Traceroute(scanParams.Target, tracePorts, scanType == "udp", scanParams.Timeout)
+	}
+
+	return host
+}
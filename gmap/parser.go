@@ -1,9 +1,13 @@
 package main
 
 import (
+	"encoding/binary"
 	"flag"
 	"fmt"
+	"gmap/scanner"
+	"gmap/services"
 	"gmap/utils"
+	"net"
 	"regexp"
 	"strconv"
 	"strings"
@@ -11,6 +15,7 @@ import (
 )
 
 var outputFlagSet bool
+var portsFlagSet bool
 
 func parseArguments() utils.Arguments {
 	var args utils.Arguments
@@ -32,6 +37,14 @@ func parseArguments() utils.Arguments {
 	flag.StringVar(&args.Target, "target", "", "Target to scan")
 
 	flag.BoolVar(&args.HostDiscovery, "Pn", false, "Do not check if host is up")
+	flag.BoolVar(&args.PingSweep, "sn", false, "Only discover live hosts, do not scan ports")
+	flag.StringVar(&args.SynPing, "PS", "", "TCP SYN discovery to the given ports")
+	flag.StringVar(&args.AckPing, "PA", "", "TCP ACK discovery to the given ports")
+	flag.StringVar(&args.UdpPing, "PU", "", "UDP discovery to the given ports")
+	flag.BoolVar(&args.EchoPing, "PE", false, "ICMP echo discovery")
+	flag.BoolVar(&args.TimestampPing, "PP", false, "ICMP timestamp discovery")
+	flag.BoolVar(&args.MaskPing, "PM", false, "ICMP address mask discovery")
+	flag.StringVar(&args.OUIFile, "oui-file", "", "OUI list used to look up the vendor of MAC addresses")
 
 	flag.StringVar(&args.ScanType, "s", "tcp", "Type of scan to perform")
 	flag.StringVar(&args.ScanType, "scan", "tcp", "Type of scan to perform")
@@ -39,9 +52,31 @@ func parseArguments() utils.Arguments {
 	flag.StringVar(&args.Format, "f", ".txt", "Format to export the file to, default to txt")
 	flag.StringVar(&args.Format, "format", ".txt", "Format to export the file to, default to txt")
 
+	flag.BoolVar(&args.OSDetection, "O", false, "Detect the OS of the host with active probes")
+
+	flag.BoolVar(&args.Versions, "sV", false, "Probe open ports to determine service and version")
+	flag.IntVar(&args.VersionIntensity, "version-intensity", services.DefaultVersionIntensity, "Highest rarity of the version probes sent (0-9)")
+	flag.StringVar(&args.ServiceProbes, "service-probes", "", "nmap-service-probes file used for version detection")
+	flag.BoolVar(&args.Enumerate, "sC", false, "Enumerate the services found on open ports")
+	flag.StringVar(&args.DNSDomain, "dns-domain", "", "Domain whose zone transfer is requested from DNS servers")
+	flag.StringVar(&args.SNMPCommunities, "snmp-communities", services.DefaultSNMPCommunities, "Comma separated communities tried on SNMP agents")
+	flag.BoolVar(&args.TLS, "tls", false, "Inspect the TLS handshake and certificates of open ports")
+	flag.BoolVar(&args.TLSVersions, "tls-versions", false, "Also list the TLS versions supported by every port speaking TLS")
+	flag.BoolVar(&args.Traceroute, "traceroute", false, "Trace the route to every live host")
+
+	flag.StringVar(&args.Zombie, "zombie", "", "Zombie host used on idle scans (e.g., 10.0.0.5:80)")
+
 	var timeout string
 	flag.StringVar(&timeout, "timeout", "1s", "Delaty timeout for packets being sent (e.g., 500ms, 2s, 1m)")
 
+	var scanDelay string
+	flag.StringVar(&scanDelay, "scan-delay", "0s", "Delay between UDP probes, 0 paces probes to the Linux ICMP rate limit once replies go missing")
+
+	flag.IntVar(&args.BannerLength, "banner-length", scanner.DefaultBannerLength, "Bytes read at most from the banner of open ports")
+
+	var bannerTimeout string
+	flag.StringVar(&bannerTimeout, "banner-timeout", scanner.DefaultBannerTimeout.String(), "Time waited for the banner of open TCP ports (e.g., 500ms, 2s)")
+
 	flag.Parse()
 
 	// Parse and check if timeout format is correct
@@ -53,11 +88,37 @@ func parseArguments() utils.Arguments {
 
 	args.Timeout = parsedTimeout
 
+	// Parse and check if scan delay format is correct
+	parsedDelay, err := time.ParseDuration(scanDelay)
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("Invalid scan delay value: %s, defaulting to 0s", scanDelay)))
+		parsedDelay = 0
+	}
+
+	args.ScanDelay = parsedDelay
+
+	// Check the banner capture limits
+	if args.BannerLength <= 0 {
+		fmt.Println(utils.PrintError(fmt.Sprintf("Invalid banner length: %d, defaulting to %d", args.BannerLength, scanner.DefaultBannerLength)))
+		args.BannerLength = scanner.DefaultBannerLength
+	}
+
+	parsedBannerTimeout, err := time.ParseDuration(bannerTimeout)
+	if err != nil || parsedBannerTimeout <= 0 {
+		fmt.Println(utils.PrintError(fmt.Sprintf("Invalid banner timeout value: %s, defaulting to %s", bannerTimeout, scanner.DefaultBannerTimeout)))
+		parsedBannerTimeout = scanner.DefaultBannerTimeout
+	}
+
+	args.BannerTimeout = parsedBannerTimeout
+
 	// Check if the output flag was explicitly set by the user
 	flag.Visit(func(f *flag.Flag) {
 		if f.Name == "o" || f.Name == "output" {
 			outputFlagSet = true
 		}
+		if f.Name == "p" || f.Name == "port" || f.Name == "p-" {
+			portsFlagSet = true
+		}
 	})
 
 	return args
@@ -159,13 +220,88 @@ func parseTarget(targetString string) (string, error) {
 	return targetString, nil
 }
 
+func parseTargets(targetString string) ([]string, error) {
+	var targets []string
+
+	// Several targets can be separated by commas
+	for _, item := range strings.Split(targetString, ",") {
+		switch {
+		// Parse a CIDR block, e.g., 192.168.1.0/24
+		case strings.Contains(item, "/"):
+			ip, network, err := net.ParseCIDR(item)
+			if err != nil || ip.To4() == nil {
+				return nil, utils.PrintError(fmt.Sprintf("invalid target network %s", item))
+			}
+
+			ones, bits := network.Mask.Size()
+			if ones < 16 {
+				return nil, utils.PrintError(fmt.Sprintf("target network %s is too large, at most /16 is allowed", item))
+			}
+
+			// Skip network and broadcast addresses unless the block is too small to have them
+			start, end := ipToInt(network.IP), ipToInt(network.IP)|(1<<(bits-ones)-1)
+			if ones < 31 {
+				start, end = start+1, end-1
+			}
+
+			for i := uint64(start); i <= uint64(end); i++ {
+				targets = append(targets, intToIp(uint32(i)).String())
+			}
+
+		// Parse a range on the last octet, e.g., 192.168.1.10-20
+		case strings.Contains(item, "-"):
+			base, last, _ := strings.Cut(item, "-")
+
+			first, err := parseTarget(base)
+			if err != nil {
+				return nil, err
+			}
+
+			end, err := strconv.Atoi(last)
+			start := int(net.ParseIP(first).To4()[3])
+			if err != nil || end < start || end > 255 {
+				return nil, utils.PrintError(fmt.Sprintf("invalid target range %s", item))
+			}
+
+			for i := start; i <= end; i++ {
+				ip := net.ParseIP(first).To4()
+				ip[3] = byte(i)
+				targets = append(targets, ip.String())
+			}
+
+		// Parse a single host
+		default:
+			target, err := parseTarget(item)
+			if err != nil {
+				return nil, err
+			}
+
+			targets = append(targets, target)
+		}
+	}
+
+	return targets, nil
+}
+
+// Auxiliary function to convert an IPv4 address to an integer
+func ipToInt(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+// Auxiliary function to convert an integer to an IPv4 address
+func intToIp(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
 func parseFormat(output string, format string) error {
 
 	if output == "" {
 		return utils.PrintError("[ERROR] output filename must be provided")
 	}
 
-	if format != "txt" && format != "json" && format != "csv" {
+	if format != "txt" && format != "json" && format != "csv" && format != "xml" {
 		return utils.PrintError("[ERROR] unsupported file provided")
 	}
 
@@ -174,13 +310,96 @@ func parseFormat(output string, format string) error {
 
 func parseScanType(scan string) (string, error) {
 
-	if scan != "udp" && scan != "tcp" && scan != "syn" {
+	if scan != "udp" && scan != "tcp" && scan != "syn" && scan != "sctp-init" && scan != "sctp-cookie" && scan != "proto" && scan != "idle" {
 		return "", utils.PrintError("[ERROR] unsupported scan type")
 	}
 
 	return scan, nil
 }
 
+func parseDiscovery(args utils.Arguments) (utils.DiscoveryParameters, error) {
+	discovery := utils.DiscoveryParameters{
+		Timeout:     args.Timeout,
+		Echo:        args.EchoPing,
+		Timestamp:   args.TimestampPing,
+		AddressMask: args.MaskPing,
+	}
+
+	// Parse the ports of every TCP and UDP probe
+	for _, probe := range []struct {
+		ports  string
+		parsed *[]int
+	}{
+		{args.SynPing, &discovery.SynPorts},
+		{args.AckPing, &discovery.AckPorts},
+		{args.UdpPing, &discovery.UdpPorts},
+	} {
+		if probe.ports == "" {
+			continue
+		}
+
+		ports, err := parsePorts(probe.ports)
+		if err != nil {
+			return discovery, err
+		}
+		*probe.parsed = ports
+	}
+
+	// Use the default probes when none were selected
+	if !discovery.Echo && !discovery.Timestamp && !discovery.AddressMask && len(discovery.SynPorts)+len(discovery.AckPorts)+len(discovery.UdpPorts) == 0 {
+		return scanner.DefaultDiscovery(args.Timeout), nil
+	}
+
+	return discovery, nil
+}
+
+func parseProtocols(protocols []int) error {
+
+	// Protocol numbers are a single byte in the IP header
+	for _, protocol := range protocols {
+		if protocol < 0 || protocol > 255 {
+			return utils.PrintError(fmt.Sprintf("[ERROR] invalid IP protocol number: %d", protocol))
+		}
+	}
+
+	return nil
+}
+
+func parseVersionIntensity(intensity int) error {
+
+	// Probe rarities go from 1 to 9, 0 only sends the NULL probe and the probes registered for the port
+	if intensity < 0 || intensity > 9 {
+		return utils.PrintError(fmt.Sprintf("[ERROR] invalid version intensity: %d, must be between 0 and 9", intensity))
+	}
+
+	return nil
+}
+
+func parseZombie(zombie string) (string, int, error) {
+
+	if zombie == "" {
+		return "", 0, utils.PrintError("[ERROR] zombie host must be provided for idle scans")
+	}
+
+	// Port defaults to 80 when only the host is given
+	host, portString, err := net.SplitHostPort(zombie)
+	if err != nil {
+		host, portString = zombie, "80"
+	}
+
+	port, err := strconv.Atoi(portString)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, utils.PrintError(fmt.Sprintf("[ERROR] invalid zombie port: %s", portString))
+	}
+
+	host, err = parseTarget(host)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return host, port, nil
+}
+
 func printHelp() {
 	fmt.Println("Help panel for gomap:")
 	fmt.Println(utils.Lines)
@@ -194,19 +413,58 @@ func printHelp() {
 	fmt.Printf("                            %sServices will automatically be scanned or obtained for all ports%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("  %s-p-                       All ports are to be scanned 0-65535%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("  %s-t, --target <IP>         Target to scan (required)%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %sAccepts single hosts, CIDR blocks and last octet ranges separated by commas, e.g., -t 10.0.0.1,192.168.1.0/24,192.168.2.10-20%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("  %s-s, --scan <SCAN>         Type of scan to perform. Options:%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("                            %stcp: Perform a TCP Scan (default)%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("                            %sudp: Perform a UDP Scan%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("                            %ssyn: Perform a SYN Scan%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %ssctp-init: Perform an SCTP INIT Scan, default ports set to %s%s\n", utils.LightGreen, utils.CommonSctpPorts, utils.Reset)
+	fmt.Printf("                            %ssctp-cookie: Perform an SCTP COOKIE-ECHO Scan%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %sidle: Perform an Idle Scan through a zombie host, requires --zombie%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %sproto: Perform an IP protocol Scan, -p selects protocol numbers (default %s)%s\n", utils.LightGreen, utils.AllProtocols, utils.Reset)
+	fmt.Printf("  %s-O                        Detect the OS with active probes against an open and a closed port%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s-sV                       Probe open TCP ports to determine service, product and version%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s--version-intensity <0-9> Probes tried by -sV, lower is faster and higher finds more services (default %d)%s\n", utils.LightGreen, services.DefaultVersionIntensity, utils.Reset)
+	fmt.Printf("  %s--service-probes <FILE>   Use an nmap-service-probes file instead of the bundled probes%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s-sC                       Enumerate the services of open TCP and UDP ports, best combined with -sV. Enumerations:%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %shttp: status code, Server header, page title, redirect location and favicon hash%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %sssh: protocol and software version, KEXINIT algorithms, weak algorithms and host key fingerprints%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %ssmb: supported dialects, signing and OS, host and domain names from the NTLMSSP challenge%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %snetbios: name table and MAC address from a node status request to UDP 137%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %sdns: recursion, version.bind and hostname.bind, EDNS and DNSSEC support%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %ssnmp: system description, name, uptime, contact and interfaces with v1/v2c communities, SNMPv3 engine ID and boots%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %sdatabases: version, authentication requirement and TLS support of MySQL, PostgreSQL, MSSQL, MongoDB, Redis, Memcached and Elasticsearch%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %sclusters: version, cluster name and anonymous access of Kafka, MQTT, CouchDB, Hadoop and InfluxDB%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %smail: SMTP, POP3 and IMAP greeting, capabilities, STARTTLS session and AUTH mechanisms, flagging plaintext auth without TLS%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %srdp: security protocols accepted (RDP, TLS, CredSSP/NLA), refusal reasons and certificate%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %svnc: RFB version and security types, flagging servers without authentication%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s--dns-domain <DOMAIN>     Domain whose zone transfer (AXFR) is requested from DNS servers found by -sC%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s--snmp-communities <LIST> Comma separated communities tried on SNMP agents (default %s)%s\n", utils.LightGreen, services.DefaultSNMPCommunities, utils.Reset)
+	fmt.Printf("  %s--tls                     Handshake with open TCP ports and record TLS version, cipher, ALPN and certificate chain%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %s-sV inspects the ports it finds speaking TLS without this flag%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s--tls-versions            Also list the TLS versions (1.0 to 1.3) supported by every port speaking TLS, implies --tls%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s--traceroute              Trace the route to every live host through an open port found by the scan%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s--zombie <IP[:PORT]>      Zombie host for idle scans, port defaults to 80%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("  %s-h, --help                Display this help message%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("  %s-o, --output <FILE>       Export output to file, default format .txt%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("  %s-f, --format <FORMAT>     Format to export the file to. Formats:%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("                            %stxt: Export to text file (default)%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("                            %scsv: Export to csv file%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("                            %sjson: Export to json file%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %sxml: Export to nmap compatible xml file%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("  %s--open                    Filter by open ports on output%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("  %s--timeout <TIMEOUT>       Timeout to be set for packets when scanning (e.g., 500ms, 2s, 1m)%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s--scan-delay <DELAY>      Delay between UDP probes (e.g., 10ms). Default paces probes to the Linux ICMP rate limit once replies go missing%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s--banner-length <BYTES>   Bytes read at most from the banner of open ports (default %d)%s\n", utils.LightGreen, scanner.DefaultBannerLength, utils.Reset)
+	fmt.Printf("  %s--banner-timeout <TIME>   Time waited for the banner of open TCP ports (default %s)%s\n", utils.LightGreen, scanner.DefaultBannerTimeout, utils.Reset)
 	fmt.Printf("  %s-Pn       		    Do not check if host is up when scanning%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s-sn                       Ping sweep: only discover live hosts, without scanning ports%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s-PS <PORTS>               TCP SYN discovery to the given ports%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s-PA <PORTS>               TCP ACK discovery to the given ports%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s-PU <PORTS>               UDP discovery to the given ports%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s-PE, -PP, -PM             ICMP echo, timestamp and address mask discovery%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("                            %sA host is up if any probe gets an answer. Default: -PE -PS 443 -PA 80 -PP%s\n", utils.LightGreen, utils.Reset)
+	fmt.Printf("  %s--oui-file <FILE>         Look up MAC vendors of ARP discovered hosts in an nmap-mac-prefixes or IEEE oui.txt file instead of the bundled sample%s\n", utils.LightGreen, utils.Reset)
 	fmt.Println(utils.Lines)
 	fmt.Printf("%sExample of use:%s\n", utils.LightGreen, utils.Reset)
 	fmt.Printf("%s./gomap -t 127.0.0.1 -p 0-65535 -o test%s\n", utils.LightGreen, utils.Reset)
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTargets(t *testing.T) {
+	tests := []struct {
+		targets string
+		want    []string
+	}{
+		{"10.0.0.1", []string{"10.0.0.1"}},
+		{"10.0.0.1,10.0.0.7", []string{"10.0.0.1", "10.0.0.7"}},
+		{"192.168.1.0/30", []string{"192.168.1.1", "192.168.1.2"}},
+		{"192.168.1.5/30", []string{"192.168.1.5", "192.168.1.6"}},
+		{"192.168.1.4/31", []string{"192.168.1.4", "192.168.1.5"}},
+		{"192.168.1.9/32", []string{"192.168.1.9"}},
+		{"192.168.2.10-12", []string{"192.168.2.10", "192.168.2.11", "192.168.2.12"}},
+		{"192.168.2.10-10", []string{"192.168.2.10"}},
+		{"10.0.0.1,172.16.0.0/30,172.16.1.254-255", []string{"10.0.0.1", "172.16.0.1", "172.16.0.2", "172.16.1.254", "172.16.1.255"}},
+	}
+
+	for _, test := range tests {
+		got, err := parseTargets(test.targets)
+		if err != nil {
+			t.Errorf("parseTargets(%q) failed: %v", test.targets, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseTargets(%q) = %v, want %v", test.targets, got, test.want)
+		}
+	}
+}
+
+func TestParseTargetsSize(t *testing.T) {
+	targets, err := parseTargets("10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(targets) != 65534 || targets[0] != "10.1.0.1" || targets[len(targets)-1] != "10.1.255.254" {
+		t.Errorf("/16 expanded to %d targets from %s to %s", len(targets), targets[0], targets[len(targets)-1])
+	}
+}
+
+func TestParseTargetsInvalid(t *testing.T) {
+	for _, targets := range []string{"10.0.0.0/15", "10.0.0.0/33", "fe80::/64", "10.0.0.20-10", "10.0.0.1-256", "10.0.0.1-x"} {
+		if got, err := parseTargets(targets); err == nil {
+			t.Errorf("parseTargets(%q) = %v, want an error", targets, got)
+		}
+	}
+}
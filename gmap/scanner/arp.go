@@ -0,0 +1,218 @@
+package scanner
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"gmap/utils"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// Discovery method for hosts found through ARP
+const MethodArp = "arp"
+
+// Sample of the OUI registry in nmap-mac-prefixes format: six hex digits followed by the vendor name
+//
+//go:embed oui.txt
+var ouiTable string
+
+var (
+	ouiVendors map[string]string
+	ouiOnce    sync.Once
+)
+
+// Auxiliary function to parse OUI prefixes, in nmap-mac-prefixes format or as the IEEE registry (00-00-0C   (hex)   Cisco Systems, Inc)
+func parseOUI(reader io.Reader) (map[string]string, error) {
+	vendors := make(map[string]string)
+
+	lines := bufio.NewScanner(reader)
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		// The IEEE registry repeats every prefix in base 16 before the vendor address
+		if line == "" || strings.HasPrefix(line, "#") || strings.Contains(line, "(base 16)") {
+			continue
+		}
+
+		if prefix, vendor, ok := strings.Cut(line, "(hex)"); ok {
+			vendors[strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(prefix), "-", ""))] = strings.TrimSpace(vendor)
+			continue
+		}
+
+		if prefix, vendor, ok := strings.Cut(line, " "); ok && len(prefix) == 6 {
+			vendors[strings.ToUpper(prefix)] = strings.TrimSpace(vendor)
+		}
+	}
+
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(vendors) == 0 {
+		return nil, errors.New("no OUI prefixes found")
+	}
+
+	return vendors, nil
+}
+
+// Use a full OUI list (e.g., nmap-mac-prefixes or the IEEE oui.txt) instead of the bundled sample
+func LoadOUIFile(file string) error {
+	reader, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	vendors, err := parseOUI(reader)
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	ouiOnce.Do(func() {
+		ouiVendors = vendors
+	})
+
+	return nil
+}
+
+// Auxiliary function to get the vendor owning the OUI of a MAC address
+func lookupVendor(mac net.HardwareAddr) string {
+	ouiOnce.Do(func() {
+		ouiVendors, _ = parseOUI(strings.NewReader(ouiTable))
+	})
+
+	if len(mac) < 3 {
+		return ""
+	}
+
+	return ouiVendors[fmt.Sprintf("%02X%02X%02X", mac[0], mac[1], mac[2])]
+}
+
+// Auxiliary function to find the Ethernet interface directly connected to a target
+func getLocalInterface(target net.IP) (*net.Interface, net.IP, bool) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, nil, false
+	}
+
+	for _, iface := range interfaces {
+		// ARP needs an interface that is up and has a hardware address
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) != 6 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			// Our own address never answers ARP requests
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil && ipNet.Contains(target) && !ipNet.IP.Equal(target) {
+				return &iface, ipNet.IP.To4(), true
+			}
+		}
+	}
+
+	return nil, nil, false
+}
+
+// Check whether a target sits in a directly connected subnet
+func IsLocalTarget(target string) bool {
+	ip := net.ParseIP(target)
+	if ip == nil {
+		return false
+	}
+
+	_, _, ok := getLocalInterface(ip)
+	return ok
+}
+
+// Discover a host on the local segment with an ARP request, returning its MAC address
+func arpPing(target string, timeout time.Duration) (net.HardwareAddr, bool) {
+	dstIp := net.ParseIP(target).To4()
+
+	iface, srcIp, ok := getLocalInterface(dstIp)
+	if !ok {
+		return nil, false
+	}
+
+	handle, err := pcap.OpenLive(iface.Name, 65536, false, 100*time.Millisecond)
+	if err != nil {
+		return nil, false
+	}
+	defer handle.Close()
+
+	// Only keep ARP replies sent by the target
+	if err := handle.SetBPFFilter(fmt.Sprintf("arp and arp[6:2] = 2 and src host %s", target)); err != nil {
+		return nil, false
+	}
+
+	ethLayer := &layers.Ethernet{
+		SrcMAC:       iface.HardwareAddr,
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: layers.EthernetTypeARP,
+	}
+
+	arpLayer := &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   iface.HardwareAddr,
+		SourceProtAddress: srcIp,
+		DstHwAddress:      make([]byte, 6),
+		DstProtAddress:    dstIp,
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	// Assemble the package
+	if err := gopacket.SerializeLayers(buffer, options, ethLayer, arpLayer); err != nil {
+		return nil, false
+	}
+
+	if err := handle.WritePacketData(buffer.Bytes()); err != nil {
+		return nil, false
+	}
+
+	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+	timeoutChan := time.After(timeout)
+
+	for {
+		select {
+		case packet, ok := <-packetSource.Packets():
+			if !ok {
+				return nil, false
+			}
+
+			if arp, ok := packet.Layer(layers.LayerTypeARP).(*layers.ARP); ok && net.IP(arp.SourceProtAddress).Equal(dstIp) {
+				return net.HardwareAddr(arp.SourceHwAddress), true
+			}
+
+		// No reply, the host is down
+		case <-timeoutChan:
+			return nil, false
+		}
+	}
+}
+
+// Discover a host on the local segment and record its MAC address and vendor
+func discoverArp(host *utils.Host, timeout time.Duration) {
+	if mac, ok := arpPing(host.Address, timeout); ok {
+		host.Status = "up"
+		host.Method = MethodArp
+		host.MAC = mac.String()
+		host.Vendor = lookupVendor(mac)
+	}
+}
@@ -0,0 +1,66 @@
+package scanner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOUI(t *testing.T) {
+	tests := []struct {
+		name  string
+		table string
+		want  map[string]string
+	}{
+		{
+			name:  "nmap-mac-prefixes",
+			table: "# comment\n\n000C29 VMware\n00000c Cisco Systems\nB827EB Raspberry Pi Foundation\n",
+			want:  map[string]string{"000C29": "VMware", "00000C": "Cisco Systems", "B827EB": "Raspberry Pi Foundation"},
+		},
+		{
+			name: "IEEE registry",
+			table: "OUI/MA-L                                                    Organization\n" +
+				"company_id                                                  Organization\n\n" +
+				"00-00-0C   (hex)\t\tCisco Systems, Inc\n" +
+				"00000C     (base 16)\t\tCisco Systems, Inc\n" +
+				"\t\t\t\t170 WEST TASMAN DRIVE\n" +
+				"\t\t\t\tSan Jose  CA  95134\n\n" +
+				"b8-27-eb   (hex)\t\tRaspberry Pi Foundation\n",
+			want: map[string]string{"00000C": "Cisco Systems, Inc", "B827EB": "Raspberry Pi Foundation"},
+		},
+	}
+
+	for _, test := range tests {
+		vendors, err := parseOUI(strings.NewReader(test.table))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		for prefix, vendor := range test.want {
+			if vendors[prefix] != vendor {
+				t.Errorf("%s: vendor of %s is %q, want %q", test.name, prefix, vendors[prefix], vendor)
+			}
+		}
+
+		if len(vendors) != len(test.want) {
+			t.Errorf("%s: %d prefixes parsed, want %d: %v", test.name, len(vendors), len(test.want), vendors)
+		}
+	}
+
+	if _, err := parseOUI(strings.NewReader("# nothing\n")); err == nil {
+		t.Error("a table without prefixes should fail")
+	}
+}
+
+func TestBundledOUI(t *testing.T) {
+	vendors, err := parseOUI(strings.NewReader(ouiTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for prefix := range vendors {
+		if len(prefix) != 6 {
+			t.Errorf("bundled prefix %q is not three bytes", prefix)
+		}
+	}
+}
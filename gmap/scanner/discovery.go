@@ -0,0 +1,327 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gmap/utils"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/go-ping/ping"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// Discovery methods recorded on the results
+const (
+	MethodUserSet       = "user-set"
+	MethodEcho          = "icmp-echo"
+	MethodTimestamp     = "icmp-timestamp"
+	MethodAddressMask   = "icmp-mask"
+	MethodTcpSyn        = "tcp-syn"
+	MethodTcpAck        = "tcp-ack"
+	MethodTcpConnect    = "tcp-connect"
+	MethodUdp           = "udp"
+	icmpTypeAddressMask = ipv4.ICMPType(17)
+	icmpTypeMaskReply   = ipv4.ICMPType(18)
+)
+
+// Ports tried when the host has to be discovered through TCP connections
+var connectPingPorts = []int{80, 443, 22, 445, 3389}
+
+// Default discovery probes when none are selected: ICMP echo, SYN to 443, ACK to 80 and ICMP timestamp
+func DefaultDiscovery(timeout time.Duration) utils.DiscoveryParameters {
+	return utils.DiscoveryParameters{
+		Timeout:   timeout,
+		Echo:      true,
+		SynPorts:  []int{443},
+		AckPorts:  []int{80},
+		Timestamp: true,
+	}
+}
+
+// Check Availability of host, a host is up as soon as any discovery probe gets an answer
+func DiscoverHost(target string, discovery utils.DiscoveryParameters) utils.Host {
+	host := utils.Host{Address: target, Status: "down"}
+	privileged := HasRawSockets()
+	start := time.Now()
+
+	// ARP always gets an answer on the local segment, so it replaces the other probes there
+	if privileged && IsLocalTarget(target) {
+		discoverArp(&host, discovery.Timeout)
+		host.Latency = time.Since(start)
+		return host
+	}
+
+	// Every probe reports the method that found the host, or an empty string
+	var probes []func() string
+
+	if discovery.Echo {
+		probes = append(probes, func() string { return echoPing(target, discovery.Timeout) })
+	}
+
+	for _, port := range discovery.SynPorts {
+		probes = append(probes, func() string { return tcpPing(target, port, true, discovery.Timeout) })
+	}
+
+	for _, port := range discovery.UdpPorts {
+		probes = append(probes, func() string { return udpPing(target, port, discovery.Timeout) })
+	}
+
+	// The remaining probes need raw sockets
+	if privileged {
+		for _, port := range discovery.AckPorts {
+			probes = append(probes, func() string { return tcpPing(target, port, false, discovery.Timeout) })
+		}
+
+		if discovery.Timestamp {
+			probes = append(probes, func() string { return icmpPing(target, ipv4.ICMPTypeTimestamp, discovery.Timeout) })
+		}
+
+		if discovery.AddressMask {
+			probes = append(probes, func() string { return icmpPing(target, icmpTypeAddressMask, discovery.Timeout) })
+		}
+	}
+
+	methods := make(chan string, len(probes))
+	for _, probe := range probes {
+		go func(probe func() string) {
+			methods <- probe()
+		}(probe)
+	}
+
+	// Stop at the first probe that succeeds
+	for range probes {
+		if method := <-methods; method != "" {
+			host.Status = "up"
+			host.Method = method
+			host.Latency = time.Since(start)
+			break
+		}
+	}
+
+	return host
+}
+
+// Discover a host with ICMP echo requests
+func echoPing(target string, timeout time.Duration) string {
+	pinger, err := ping.NewPinger(target)
+
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Failed to create pinger for target %s", target)))
+		return ""
+	}
+
+	// Establish parameters for pinger
+	pinger.Count = 3
+	pinger.Timeout = timeout
+	// Without raw sockets fall back to unprivileged ICMP datagram sockets
+	pinger.SetPrivileged(HasRawSockets())
+	// A single reply is enough
+	pinger.OnRecv = func(*ping.Packet) {
+		pinger.Stop()
+	}
+
+	// Block until finished
+	err = pinger.Run()
+
+	if err != nil {
+		// ICMP datagram sockets may be disabled too (net.ipv4.ping_group_range)
+		if !HasRawSockets() {
+			return connectPing(target, timeout)
+		}
+
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Ping failed for target %s", target)))
+		return ""
+	}
+
+	if pinger.Statistics().PacketsRecv > 0 {
+		return MethodEcho
+	}
+
+	return ""
+}
+
+// Discover a host through TCP connections, which need no privileges
+func connectPing(target string, timeout time.Duration) string {
+	for _, port := range connectPingPorts {
+		if connectPort(target, port, timeout) {
+			return fmt.Sprintf("%s/%d", MethodTcpConnect, port)
+		}
+	}
+
+	return ""
+}
+
+// Auxiliary function to check whether a TCP connection to a port gets any answer
+func connectPort(target string, port int, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(target, strconv.Itoa(port)), timeout)
+
+	// Both an accepted and a refused connection prove the host is up
+	if err == nil {
+		conn.Close()
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// Discover a host with a TCP SYN or ACK packet, any SYN/ACK or RST back means it is up
+func tcpPing(target string, port int, syn bool, timeout time.Duration) string {
+	method := MethodTcpAck
+	if syn {
+		method = MethodTcpSyn
+	}
+
+	// Unprivileged SYN pings complete the handshake instead
+	if !HasRawSockets() {
+		if connectPort(target, port, timeout) {
+			return fmt.Sprintf("%s/%d", MethodTcpConnect, port)
+		}
+		return ""
+	}
+
+	srcIp, err := getSourceIp(target)
+	if err != nil {
+		return ""
+	}
+
+	conn, err := openRawConn(layers.IPProtocolTCP)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+
+	dstIp := net.ParseIP(target)
+	srcPort := layers.TCPPort(32768 + rand.Intn(28232))
+
+	ipLayer := &layers.IPv4{
+		SrcIP:    srcIp,
+		DstIP:    dstIp,
+		Protocol: layers.IPProtocolTCP,
+	}
+
+	tcpLayer := &layers.TCP{
+		SrcPort: srcPort,
+		DstPort: layers.TCPPort(port),
+		SYN:     syn,
+		ACK:     !syn,
+		Seq:     rand.Uint32(),
+		Window:  1024,
+	}
+	tcpLayer.SetNetworkLayerForChecksum(ipLayer)
+
+	if err := sendRaw(conn, ipLayer, tcpLayer); err != nil {
+		return ""
+	}
+
+	buffer := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	for {
+		header, payload, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return ""
+		}
+
+		if !header.Src.Equal(dstIp) {
+			continue
+		}
+
+		packet := gopacket.NewPacket(payload, layers.LayerTypeTCP, gopacket.Default)
+		if tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); ok && tcp.DstPort == srcPort && (tcp.RST || tcp.SYN && tcp.ACK) {
+			return fmt.Sprintf("%s/%d", method, port)
+		}
+	}
+}
+
+// Discover a host with an empty UDP datagram, a reply or a port unreachable means it is up
+func udpPing(target string, port int, timeout time.Duration) string {
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(target, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte{}); err != nil {
+		return ""
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buffer := make([]byte, 1024)
+	_, err = conn.Read(buffer)
+
+	// Connected UDP sockets report an ICMP port unreachable as a refused connection
+	if err == nil || errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Sprintf("%s/%d", MethodUdp, port)
+	}
+
+	return ""
+}
+
+// Discover a host with an ICMP timestamp or address mask request
+func icmpPing(target string, requestType ipv4.ICMPType, timeout time.Duration) string {
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+
+	id := os.Getpid() & 0xffff
+	replyType, method := ipv4.ICMPTypeTimestampReply, MethodTimestamp
+
+	// Identifier and sequence number, followed by the originate timestamp or an empty mask
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint16(data[0:2], uint16(id))
+	binary.BigEndian.PutUint16(data[2:4], 1)
+
+	if requestType == icmpTypeAddressMask {
+		data = data[:8]
+		replyType, method = icmpTypeMaskReply, MethodAddressMask
+	} else {
+		now := time.Now().UTC()
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		binary.BigEndian.PutUint32(data[4:8], uint32(now.Sub(midnight).Milliseconds()))
+	}
+
+	request := icmp.Message{Type: requestType, Body: &icmp.RawBody{Data: data}}
+	packet, err := request.Marshal(nil)
+	if err != nil {
+		return ""
+	}
+
+	dstIp := net.ParseIP(target)
+	if _, err := conn.WriteTo(packet, &net.IPAddr{IP: dstIp}); err != nil {
+		return ""
+	}
+
+	buffer := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return ""
+		}
+
+		if ipAddr, ok := addr.(*net.IPAddr); !ok || !ipAddr.IP.Equal(dstIp) {
+			continue
+		}
+
+		reply, err := icmp.ParseMessage(ipv4.ICMPTypeEcho.Protocol(), buffer[:n])
+		if err != nil || reply.Type != replyType {
+			continue
+		}
+
+		if body, ok := reply.Body.(*icmp.RawBody); ok && len(body.Data) >= 2 && int(binary.BigEndian.Uint16(body.Data[0:2])) == id {
+			return method
+		}
+	}
+}
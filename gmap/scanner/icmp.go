@@ -0,0 +1,262 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"fmt"
+	"gmap/utils"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// ICMP destination unreachable codes used to classify ports
+const (
+	icmpProtocolUnreachable = 2
+	icmpPortUnreachable     = 3
+)
+
+// Linux only answers with one ICMP error per second to a given host after an initial burst of 6
+const (
+	linuxICMPBurst    = 6
+	linuxICMPInterval = time.Second
+	// Probes sent at once before checking whether ICMP errors are rate limited
+	rateLimitBatch = 2 * linuxICMPBurst
+)
+
+// Unreachable codes which mean a firewall or router dropped the probe
+var icmpFilteredCodes = map[int]bool{
+	0:  true, // Network unreachable
+	1:  true, // Host unreachable
+	2:  true, // Protocol unreachable
+	3:  true, // Port unreachable
+	9:  true, // Network administratively prohibited
+	10: true, // Host administratively prohibited
+	13: true, // Communication administratively prohibited
+}
+
+// Identifies the probe an ICMP error was generated for
+type icmpKey struct {
+	protocol int
+	port     int
+}
+
+// Collects ICMP destination unreachable replies sent back from a target
+type icmpListener struct {
+	conn   *icmp.PacketConn
+	target net.IP
+	mutex  sync.Mutex
+	codes  map[icmpKey]int
+}
+
+// Open a raw ICMP socket and start listening for unreachable replies about target
+func newICMPListener(target string) (*icmpListener, error) {
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return nil, err
+	}
+
+	listener := &icmpListener{
+		conn:   conn,
+		target: net.ParseIP(target),
+		codes:  make(map[icmpKey]int),
+	}
+
+	go listener.listen()
+
+	return listener, nil
+}
+
+// Read ICMP messages until the socket is closed
+func (l *icmpListener) listen() {
+	buffer := make([]byte, 1500)
+
+	for {
+		n, _, err := l.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+
+		message, err := icmp.ParseMessage(ipv4.ICMPTypeEcho.Protocol(), buffer[:n])
+		if err != nil || message.Type != ipv4.ICMPTypeDestinationUnreachable {
+			continue
+		}
+
+		body, ok := message.Body.(*icmp.DstUnreach)
+		if !ok {
+			continue
+		}
+
+		// The error quotes the header of the original probe, use it to find which one it belongs to
+		key, ok := l.parseQuoted(body.Data)
+		if !ok {
+			continue
+		}
+
+		l.mutex.Lock()
+		l.codes[key] = message.Code
+		l.mutex.Unlock()
+	}
+}
+
+// Auxiliary function to extract protocol and destination port of a quoted datagram
+func (l *icmpListener) parseQuoted(data []byte) (icmpKey, bool) {
+	header, err := ipv4.ParseHeader(data)
+	if err != nil || !header.Dst.Equal(l.target) {
+		return icmpKey{}, false
+	}
+
+	key := icmpKey{protocol: header.Protocol}
+
+	// UDP, TCP and SCTP all carry the destination port in bytes 2-3 of their header
+	if payload := data[header.Len:]; len(payload) >= 4 {
+		key.port = int(binary.BigEndian.Uint16(payload[2:4]))
+	}
+
+	return key, true
+}
+
+// Return the unreachable code received for a probe, if any
+func (l *icmpListener) lookup(protocol int, port int) (int, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	code, ok := l.codes[icmpKey{protocol: protocol, port: port}]
+	return code, ok
+}
+
+// Stop listening
+func (l *icmpListener) close() {
+	l.conn.Close()
+}
+
+// Auxiliary function to look up an ICMP unreachable reply when the listener is available
+func lookupUnreachable(listener *icmpListener, protocol layers.IPProtocol, port int) (int, bool) {
+	if listener == nil {
+		return 0, false
+	}
+
+	return listener.lookup(int(protocol), port)
+}
+
+// Auxiliary function to map an ICMP unreachable code to a port state
+func unreachableState(code int, closedCode int) (string, bool) {
+	if code == closedCode {
+		return "closed", true
+	}
+
+	if icmpFilteredCodes[code] {
+		return "filtered", true
+	}
+
+	return "", false
+}
+
+// Token bucket spacing out probes so that rate limited ICMP replies are not lost
+type pacer struct {
+	burst    int
+	tokens   int
+	interval time.Duration
+	last     time.Time
+}
+
+// Create a pacer, a zero delay mimics the Linux ICMP rate limit once its burst has been spent
+func newPacer(delay time.Duration) *pacer {
+	if delay > 0 {
+		return &pacer{burst: 1, tokens: 1, interval: delay, last: time.Now()}
+	}
+
+	return &pacer{burst: linuxICMPBurst, tokens: 0, interval: linuxICMPInterval, last: time.Now()}
+}
+
+// Block until the next probe may be sent
+func (p *pacer) wait() {
+	// Refill the tokens earned since the last refill
+	if refill := int(time.Since(p.last) / p.interval); refill > 0 {
+		p.tokens = min(p.burst, p.tokens+refill)
+		p.last = p.last.Add(time.Duration(refill) * p.interval)
+	}
+
+	if p.tokens == 0 {
+		time.Sleep(time.Until(p.last.Add(p.interval)))
+		p.last = p.last.Add(p.interval)
+		p.tokens = 1
+	}
+
+	p.tokens--
+}
+
+// Auxiliary function to run a probe worker for every port, waiting on the pacer when there is one
+func runProbes(ports []int, pace *pacer, probe func(port int, results chan<- utils.Port, wg *sync.WaitGroup)) []utils.Port {
+	var results []utils.Port
+	resultChan := make(chan utils.Port, len(ports))
+	var wg sync.WaitGroup
+
+	for _, port := range ports {
+		if pace != nil {
+			pace.wait()
+		}
+		wg.Add(1)
+		go probe(port, resultChan, &wg)
+	}
+
+	wg.Wait()
+	close(resultChan)
+
+	for result := range resultChan {
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// Auxiliary function to tell whether the ICMP errors of a batch stopped right after the Linux burst
+func rateLimited(results []utils.Port) bool {
+	answered, unanswered := 0, 0
+
+	for _, result := range results {
+		switch result.Status {
+		case "closed", "filtered":
+			answered++
+		case "open/filtered":
+			unanswered++
+		}
+	}
+
+	// Probes sent at once get the burst plus at most the token earned meanwhile
+	return unanswered > 0 && answered >= linuxICMPBurst && answered <= linuxICMPBurst+1
+}
+
+// Send a probe per port, a zero delay sends them freely until the target is seen rate limiting its ICMP errors
+func pacedProbes(target string, ports []int, delay time.Duration, probe func(port int, results chan<- utils.Port, wg *sync.WaitGroup)) []utils.Port {
+	if delay > 0 {
+		return runProbes(ports, newPacer(delay), probe)
+	}
+
+	// A first batch larger than the burst shows whether replies go missing
+	batch := ports[:min(rateLimitBatch, len(ports))]
+	results := runProbes(batch, nil, probe)
+
+	if !rateLimited(results) {
+		return append(results, runProbes(ports[len(batch):], nil, probe)...)
+	}
+
+	// Keep the answers and probe again the ports whose reply may have been dropped
+	var answered []utils.Port
+	var pending []int
+	for _, result := range results {
+		if result.Status == "open/filtered" {
+			pending = append(pending, result.Port)
+		} else {
+			answered = append(answered, result)
+		}
+	}
+	pending = append(pending, ports[len(batch):]...)
+
+	fmt.Printf("%s[*] ICMP replies from %s are rate limited, pacing the remaining %d probes to one per %s (about %s)%s\n", utils.Blue, target, len(pending), linuxICMPInterval, time.Duration(len(pending))*linuxICMPInterval, utils.Reset)
+
+	return append(answered, runProbes(pending, newPacer(0), probe)...)
+}
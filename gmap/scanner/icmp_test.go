@@ -0,0 +1,40 @@
+package scanner
+
+import (
+	"gmap/utils"
+	"testing"
+)
+
+// Auxiliary function to build a batch of results with a number of ports in each state
+func batchOf(states map[string]int) []utils.Port {
+	var results []utils.Port
+	for state, count := range states {
+		for i := 0; i < count; i++ {
+			results = append(results, utils.Port{Port: len(results) + 1, Status: state})
+		}
+	}
+
+	return results
+}
+
+func TestRateLimited(t *testing.T) {
+	tests := []struct {
+		name   string
+		states map[string]int
+		want   bool
+	}{
+		{"burst then silence", map[string]int{"closed": 6, "open/filtered": 6}, true},
+		{"burst and one refill", map[string]int{"closed": 5, "filtered": 2, "open/filtered": 5}, true},
+		{"every probe answered", map[string]int{"closed": 12}, false},
+		{"no ICMP errors", map[string]int{"open/filtered": 12}, false},
+		{"fewer errors than the burst", map[string]int{"closed": 3, "open/filtered": 9}, false},
+		{"more errors than the burst", map[string]int{"closed": 9, "open/filtered": 3}, false},
+		{"open ports do not count", map[string]int{"closed": 6, "open": 6}, false},
+	}
+
+	for _, test := range tests {
+		if got := rateLimited(batchOf(test.states)); got != test.want {
+			t.Errorf("%s: rateLimited = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
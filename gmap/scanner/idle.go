@@ -0,0 +1,229 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"gmap/utils"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/ipv4"
+)
+
+// Zombie suitability parameters
+const (
+	zombieProbes   = 6
+	zombieInterval = 100 * time.Millisecond
+	// IP ID jumps above this are considered random rather than incremental
+	zombieMaxIncrement = 1000
+	// Attempts per port before giving up on a noisy zombie
+	idleRetries = 3
+)
+
+// Probes the zombie and spoofs packets on its behalf
+type idleProber struct {
+	conn       *ipv4.RawConn
+	srcIp      net.IP
+	zombie     net.IP
+	zombiePort layers.TCPPort
+	srcPort    layers.TCPPort
+	timeout    time.Duration
+}
+
+// Send a SYN/ACK to the zombie and return the IP ID of the RST it answers with
+func (p *idleProber) probeIpId() (int, error) {
+	// Use a new source port every time so replies cannot be mixed up
+	p.srcPort++
+
+	ipLayer := &layers.IPv4{
+		SrcIP:    p.srcIp,
+		DstIP:    p.zombie,
+		Protocol: layers.IPProtocolTCP,
+	}
+
+	tcpLayer := &layers.TCP{
+		SrcPort: p.srcPort,
+		DstPort: p.zombiePort,
+		SYN:     true,
+		ACK:     true,
+		Seq:     rand.Uint32(),
+		Ack:     rand.Uint32(),
+		Window:  1024,
+	}
+	tcpLayer.SetNetworkLayerForChecksum(ipLayer)
+
+	if err := sendRaw(p.conn, ipLayer, tcpLayer); err != nil {
+		return 0, err
+	}
+
+	buffer := make([]byte, 1500)
+	p.conn.SetReadDeadline(time.Now().Add(p.timeout))
+
+	for {
+		header, payload, _, err := p.conn.ReadFrom(buffer)
+		if err != nil {
+			return 0, err
+		}
+
+		if !header.Src.Equal(p.zombie) {
+			continue
+		}
+
+		packet := gopacket.NewPacket(payload, layers.LayerTypeTCP, gopacket.Default)
+		if tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); ok && tcp.RST && tcp.DstPort == p.srcPort {
+			return header.ID, nil
+		}
+	}
+}
+
+// Send a SYN to the target pretending to be the zombie
+func (p *idleProber) spoofSyn(target net.IP, port int) error {
+	ipLayer := &layers.IPv4{
+		SrcIP:    p.zombie,
+		DstIP:    target,
+		Protocol: layers.IPProtocolTCP,
+	}
+
+	tcpLayer := &layers.TCP{
+		SrcPort: layers.TCPPort(32768 + rand.Intn(28232)),
+		DstPort: layers.TCPPort(port),
+		SYN:     true,
+		Seq:     rand.Uint32(),
+		Window:  1024,
+	}
+	tcpLayer.SetNetworkLayerForChecksum(ipLayer)
+
+	return sendRaw(p.conn, ipLayer, tcpLayer)
+}
+
+// Auxiliary function to get the increment between two IP IDs
+func ipIdDiff(previous int, current int) int {
+	// IP IDs are 16 bits long and wrap around
+	return (current - previous + 65536) % 65536
+}
+
+// Check that the zombie has a predictable IP ID sequence and no traffic of its own
+func (p *idleProber) checkZombie() error {
+	var ids []int
+
+	for i := 0; i < zombieProbes; i++ {
+		id, err := p.probeIpId()
+		if err != nil {
+			return fmt.Errorf("zombie %s did not answer on port %d", p.zombie, p.zombiePort)
+		}
+
+		ids = append(ids, id)
+		time.Sleep(zombieInterval)
+	}
+
+	total := 0
+
+	for i := 1; i < len(ids); i++ {
+		diff := ipIdDiff(ids[i-1], ids[i])
+
+		switch {
+		case ids[i] == 0 && ids[i-1] == 0:
+			return errors.New("zombie sets the IP ID of every packet to zero")
+		case diff == 0:
+			return fmt.Errorf("zombie uses a constant IP ID (%d)", ids[i])
+		case diff > zombieMaxIncrement:
+			return errors.New("zombie uses a random IP ID sequence")
+		}
+
+		total += diff
+	}
+
+	// Each probe should increase the IP ID by exactly one on an idle host
+	if increment := float64(total) / float64(len(ids)-1); increment > 1.5 {
+		return fmt.Errorf("zombie is not idle, its IP ID increased by %.1f per probe instead of 1", increment)
+	}
+
+	return nil
+}
+
+// Infer the state of a target port from the zombie's IP ID increments
+func (p *idleProber) probePort(target net.IP, port int, wait time.Duration) string {
+	for attempt := 0; attempt < idleRetries; attempt++ {
+		before, err := p.probeIpId()
+		if err != nil {
+			continue
+		}
+
+		if err := p.spoofSyn(target, port); err != nil {
+			fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Failed to send spoofed SYN to port %d: %v", port, err)))
+			return "unknown"
+		}
+
+		// Give the target and zombie time to exchange SYN/ACK and RST
+		time.Sleep(wait)
+
+		after, err := p.probeIpId()
+		if err != nil {
+			continue
+		}
+
+		// One increment is our own probe, a second one means the zombie answered the target's SYN/ACK
+		switch ipIdDiff(before, after) {
+		case 1:
+			return "closed/filtered"
+		case 2:
+			return "open"
+		}
+	}
+
+	// The zombie kept sending traffic of its own
+	return "unknown"
+}
+
+// Function to perform an Idle (zombie) Scan
+func IdleScan(scan utils.ScanParameters) []utils.Port {
+	var results []utils.Port
+
+	fmt.Printf("%s[*] Starting Idle scan on host %s through zombie %s:%d%s\n", utils.Blue, scan.Target, scan.Zombie, scan.ZombiePort, utils.Reset)
+	fmt.Println(utils.Lines)
+
+	srcIp, err := getSourceIp(scan.Zombie)
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not get local IP: %v", err)))
+		return results
+	}
+
+	conn, err := openRawConn(layers.IPProtocolTCP)
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not open raw TCP socket: %v", err)))
+		return results
+	}
+	defer conn.Close()
+
+	prober := &idleProber{
+		conn:       conn,
+		srcIp:      srcIp,
+		zombie:     net.ParseIP(scan.Zombie),
+		zombiePort: layers.TCPPort(scan.ZombiePort),
+		srcPort:    layers.TCPPort(32768 + rand.Intn(20000)),
+		timeout:    scan.Timeout,
+	}
+
+	// Make sure the zombie can be trusted before using it
+	if err := prober.checkZombie(); err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Zombie %s rejected: %v", scan.Zombie, err)))
+		return results
+	}
+	utils.PrintSuccess(fmt.Sprintf("[!] Zombie %s has an incremental IP ID sequence and is idle", scan.Zombie))
+
+	// Ports are probed one at a time, concurrent probes would blur the IP ID increments
+	target := net.ParseIP(scan.Target)
+	for _, port := range scan.Ports {
+		state := prober.probePort(target, port, scan.Timeout)
+		results = append(results, utils.Port{Port: port, Status: state, Service: checkService(utils.CommonServices[port])})
+	}
+
+	fmt.Println(utils.Lines)
+	fmt.Printf("%s[*] Idle Scan finished on host %s%s\n", utils.Blue, scan.Target, utils.Reset)
+	fmt.Printf("%s[*] %d ports scanned %d up %s\n", utils.Blue, len(scan.Ports), countOpenPorts(results), utils.Reset)
+
+	return results
+}
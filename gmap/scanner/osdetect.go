@@ -0,0 +1,610 @@
+package scanner
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+	"gmap/utils"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// OS detection parameters
+const (
+	// SYNs sent to the open port to sample its IP ID sequence, the first one is T1
+	osSeqProbes   = 6
+	osSeqInterval = 100 * time.Millisecond
+	// ICMP code set on the echo request, most stacks reply with code 0
+	osEchoCode = 9
+	// Matches below this accuracy are not reported
+	osMinAccuracy = 85
+	osMaxMatches  = 5
+	// UDP port expected to be closed on every host
+	osClosedUdpPort = 40125
+)
+
+// Signature database, see the header of the file for its format
+//
+//go:embed os-fingerprints
+var osFingerprintTable string
+
+// Attributes of a single test, e.g. R=Y, DF=N
+type osTest map[string]string
+
+// Results of every test, indexed by test name
+type osFingerprint map[string]osTest
+
+// Order in which tests are printed
+var osTestNames = []string{"SEQ", "T1", "T2", "T3", "T4", "T5", "T6", "T7", "IE", "U1"}
+
+// Weight of every attribute when matching, attributes not listed weigh 10
+var osAttributeWeights = map[string]int{
+	"TI":  100,
+	"CI":  50,
+	"R":   50,
+	"F":   30,
+	"CD":  30,
+	"W":   25,
+	"DF":  20,
+	"O":   20,
+	"A":   20,
+	"S":   20,
+	"TTL": 15,
+}
+
+// TCP probes sent to the open port (T1-T4) and the closed port (T5-T7)
+var osTcpProbes = []struct {
+	name   string
+	closed bool
+	flags  string
+}{
+	{name: "T2", flags: ""},
+	{name: "T3", flags: "SFPU"},
+	{name: "T4", flags: "A"},
+	{name: "T5", closed: true, flags: "S"},
+	{name: "T6", closed: true, flags: "A"},
+	{name: "T7", closed: true, flags: "FPU"},
+}
+
+// Entry of the signature database
+type osEntry struct {
+	name  string
+	cpe   []string
+	tests osFingerprint
+}
+
+var (
+	osEntries     []osEntry
+	osEntriesOnce sync.Once
+)
+
+// Auxiliary function to parse a test line such as T1(R=Y%DF=Y)
+func parseOSTest(line string) (string, osTest, bool) {
+	name, rest, ok := strings.Cut(line, "(")
+	if !ok || !strings.HasSuffix(rest, ")") {
+		return "", nil, false
+	}
+
+	test := make(osTest)
+	for _, pair := range strings.Split(strings.TrimSuffix(rest, ")"), "%") {
+		if attribute, value, ok := strings.Cut(pair, "="); ok {
+			test[attribute] = value
+		}
+	}
+
+	return name, test, true
+}
+
+// Auxiliary function to load the bundled signature database
+func loadOSEntries() []osEntry {
+	osEntriesOnce.Do(func() {
+		lines := bufio.NewScanner(strings.NewReader(osFingerprintTable))
+
+		for lines.Scan() {
+			line := strings.TrimSpace(lines.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			if name, ok := strings.CutPrefix(line, "Fingerprint "); ok {
+				osEntries = append(osEntries, osEntry{name: name, tests: make(osFingerprint)})
+				continue
+			}
+
+			// Anything else belongs to the last entry
+			if len(osEntries) == 0 {
+				continue
+			}
+			entry := &osEntries[len(osEntries)-1]
+
+			if cpe, ok := strings.CutPrefix(line, "CPE "); ok {
+				entry.cpe = append(entry.cpe, cpe)
+			} else if name, test, ok := parseOSTest(line); ok {
+				entry.tests[name] = test
+			}
+		}
+	})
+
+	return osEntries
+}
+
+// Format a fingerprint the same way signatures are written
+func (f osFingerprint) String() string {
+	var tests []string
+
+	for _, name := range osTestNames {
+		test, ok := f[name]
+		if !ok {
+			continue
+		}
+
+		attributes := make([]string, 0, len(test))
+		for attribute, value := range test {
+			attributes = append(attributes, attribute+"="+value)
+		}
+		sort.Strings(attributes)
+
+		tests = append(tests, fmt.Sprintf("%s(%s)", name, strings.Join(attributes, "%")))
+	}
+
+	return strings.Join(tests, " ")
+}
+
+// Percentage of the weighted attributes of a fingerprint matching an entry
+func (e osEntry) accuracy(fingerprint osFingerprint) int {
+	matched, possible := 0, 0
+
+	for name, test := range e.tests {
+		observed, ok := fingerprint[name]
+		if !ok {
+			continue
+		}
+
+		// Only attributes present on both sides are compared
+		for attribute, expected := range test {
+			value, ok := observed[attribute]
+			if !ok {
+				continue
+			}
+
+			weight, ok := osAttributeWeights[attribute]
+			if !ok {
+				weight = 10
+			}
+			possible += weight
+
+			for _, alternative := range strings.Split(expected, "|") {
+				if alternative == value {
+					matched += weight
+					break
+				}
+			}
+		}
+	}
+
+	if possible == 0 {
+		return 0
+	}
+
+	return matched * 100 / possible
+}
+
+// Sends the OS detection probes and collects the replies
+type osProber struct {
+	tcpConn  *ipv4.RawConn
+	icmpConn *ipv4.RawConn
+	srcIp    net.IP
+	target   net.IP
+	srcPort  layers.TCPPort
+	timeout  time.Duration
+}
+
+// Auxiliary function to turn a boolean into a Y/N attribute
+func yesNo(value bool) string {
+	if value {
+		return "Y"
+	}
+
+	return "N"
+}
+
+// Auxiliary function to get the initial TTL of a reply as a test attribute
+func ttlAttribute(ttl int) string {
+	return fmt.Sprintf("%X", initialTTL(ttl))
+}
+
+// Auxiliary function to describe the flags of a TCP segment
+func tcpFlags(tcp *layers.TCP) string {
+	var flags strings.Builder
+
+	for _, flag := range []struct {
+		set    bool
+		letter string
+	}{{tcp.URG, "U"}, {tcp.ACK, "A"}, {tcp.PSH, "P"}, {tcp.RST, "R"}, {tcp.SYN, "S"}, {tcp.FIN, "F"}} {
+		if flag.set {
+			flags.WriteString(flag.letter)
+		}
+	}
+
+	return flags.String()
+}
+
+// Auxiliary function to compare a number of the reply with the ones sent on the probe
+func relativeNumber(value uint32, zeroMatch uint32, letter string) string {
+	switch value {
+	case 0:
+		return "Z"
+	case zeroMatch:
+		return letter
+	case zeroMatch + 1:
+		return letter + "+"
+	}
+
+	return "O"
+}
+
+// Auxiliary function to classify a sequence of IP IDs
+func ipIdSequence(ids []int) string {
+	zero := true
+	maxDiff := 0
+
+	for i, id := range ids {
+		if id != 0 {
+			zero = false
+		}
+
+		if i > 0 {
+			maxDiff = max(maxDiff, ipIdDiff(ids[i-1], id))
+		}
+	}
+
+	switch {
+	case zero:
+		return "Z"
+	case maxDiff < 10:
+		return "I"
+	case maxDiff > 20000:
+		return "RD"
+	}
+
+	return "RI"
+}
+
+// Send a TCP probe with the given flags and return its test attributes and the IP ID of the reply
+func (p *osProber) probeTcp(port int, flags string) (osTest, int, bool) {
+	// Use a new source port every time so replies cannot be mixed up
+	p.srcPort++
+
+	ipLayer := &layers.IPv4{
+		SrcIP:    p.srcIp,
+		DstIP:    p.target,
+		Protocol: layers.IPProtocolTCP,
+	}
+
+	tcpLayer := &layers.TCP{
+		SrcPort: p.srcPort,
+		DstPort: layers.TCPPort(port),
+		SYN:     strings.Contains(flags, "S"),
+		ACK:     strings.Contains(flags, "A"),
+		FIN:     strings.Contains(flags, "F"),
+		PSH:     strings.Contains(flags, "P"),
+		URG:     strings.Contains(flags, "U"),
+		Seq:     rand.Uint32(),
+		Ack:     rand.Uint32(),
+		Window:  1024,
+		Options: synOptions,
+	}
+	tcpLayer.SetNetworkLayerForChecksum(ipLayer)
+
+	if err := sendRaw(p.tcpConn, ipLayer, tcpLayer); err != nil {
+		return osTest{"R": "N"}, 0, false
+	}
+
+	buffer := make([]byte, 1500)
+	p.tcpConn.SetReadDeadline(time.Now().Add(p.timeout))
+
+	for {
+		header, payload, _, err := p.tcpConn.ReadFrom(buffer)
+		if err != nil {
+			return osTest{"R": "N"}, 0, false
+		}
+
+		if !header.Src.Equal(p.target) {
+			continue
+		}
+
+		packet := gopacket.NewPacket(payload, layers.LayerTypeTCP, gopacket.Default)
+		tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if !ok || tcp.DstPort != p.srcPort {
+			continue
+		}
+
+		test := osTest{
+			"R":   "Y",
+			"DF":  yesNo(header.Flags&ipv4.DontFragment != 0),
+			"TTL": ttlAttribute(header.TTL),
+			"W":   fmt.Sprintf("%X", tcp.Window),
+			"F":   tcpFlags(tcp),
+			"A":   relativeNumber(tcp.Ack, tcpLayer.Seq, "S"),
+			"S":   relativeNumber(tcp.Seq, tcpLayer.Ack, "A"),
+		}
+
+		// Options are only meaningful on SYN/ACKs
+		if tcp.SYN {
+			test["O"] = tcpOptionOrder(tcp)
+		}
+
+		return test, header.ID, true
+	}
+}
+
+// Send an ICMP echo request with a non zero code and return the test attributes of the reply
+func (p *osProber) probeEcho() osTest {
+	id := uint16(os.Getpid())
+
+	ipLayer := &layers.IPv4{
+		SrcIP:    p.srcIp,
+		DstIP:    p.target,
+		Protocol: layers.IPProtocolICMPv4,
+		Flags:    layers.IPv4DontFragment,
+	}
+
+	icmpLayer := &layers.ICMPv4{
+		TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, osEchoCode),
+		Id:       id,
+		Seq:      1,
+	}
+
+	if err := sendRaw(p.icmpConn, ipLayer, icmpLayer, gopacket.Payload(make([]byte, 120))); err != nil {
+		return osTest{"R": "N"}
+	}
+
+	return p.readIcmp(func(reply *icmp.Message) bool {
+		echo, ok := reply.Body.(*icmp.Echo)
+		return ok && reply.Type == ipv4.ICMPTypeEchoReply && echo.ID == int(id)
+	}, func(test osTest, reply *icmp.Message) {
+		switch reply.Code {
+		case 0:
+			test["CD"] = "Z"
+		case osEchoCode:
+			test["CD"] = "S"
+		default:
+			test["CD"] = "O"
+		}
+	})
+}
+
+// Send a UDP datagram to a closed port and return the test attributes of the port unreachable
+func (p *osProber) probeUdp(port int) osTest {
+	srcPort := layers.UDPPort(p.srcPort)
+
+	ipLayer := &layers.IPv4{
+		SrcIP:    p.srcIp,
+		DstIP:    p.target,
+		Protocol: layers.IPProtocolUDP,
+	}
+
+	udpLayer := &layers.UDP{SrcPort: srcPort, DstPort: layers.UDPPort(port)}
+	udpLayer.SetNetworkLayerForChecksum(ipLayer)
+
+	if err := sendRaw(p.icmpConn, ipLayer, udpLayer, gopacket.Payload([]byte(strings.Repeat("C", 300)))); err != nil {
+		return osTest{"R": "N"}
+	}
+
+	return p.readIcmp(func(reply *icmp.Message) bool {
+		unreachable, ok := reply.Body.(*icmp.DstUnreach)
+		if !ok || reply.Code != icmpPortUnreachable {
+			return false
+		}
+
+		// The error quotes the header of our datagram
+		quoted, err := ipv4.ParseHeader(unreachable.Data)
+		return err == nil && len(unreachable.Data) >= quoted.Len+2 && binary.BigEndian.Uint16(unreachable.Data[quoted.Len:]) == uint16(srcPort)
+	}, nil)
+}
+
+// Auxiliary function to wait for an ICMP message from the target accepted by match
+func (p *osProber) readIcmp(match func(*icmp.Message) bool, describe func(osTest, *icmp.Message)) osTest {
+	buffer := make([]byte, 1500)
+	p.icmpConn.SetReadDeadline(time.Now().Add(p.timeout))
+
+	for {
+		header, payload, _, err := p.icmpConn.ReadFrom(buffer)
+		if err != nil {
+			return osTest{"R": "N"}
+		}
+
+		if !header.Src.Equal(p.target) {
+			continue
+		}
+
+		reply, err := icmp.ParseMessage(ipv4.ICMPTypeEcho.Protocol(), payload)
+		if err != nil || !match(reply) {
+			continue
+		}
+
+		test := osTest{
+			"R":   "Y",
+			"DF":  yesNo(header.Flags&ipv4.DontFragment != 0),
+			"TTL": ttlAttribute(header.TTL),
+		}
+
+		if describe != nil {
+			describe(test, reply)
+		}
+
+		return test
+	}
+}
+
+// Auxiliary function to pick a port that was not scanned, assumed to be closed
+func unscannedPort(ports []utils.Port) int {
+	scanned := make(map[int]bool)
+	for _, port := range ports {
+		scanned[port.Port] = true
+	}
+
+	for {
+		if port := 40000 + rand.Intn(20000); !scanned[port] {
+			return port
+		}
+	}
+}
+
+// Build the fingerprint of a host from an open and a closed TCP port
+func (p *osProber) fingerprint(openPort int, closedPort int) osFingerprint {
+	fingerprint := make(osFingerprint)
+	seq := make(osTest)
+
+	if openPort > 0 {
+		var ids []int
+
+		// The first SYN doubles as T1
+		for i := 0; i < osSeqProbes; i++ {
+			test, id, ok := p.probeTcp(openPort, "S")
+			if i == 0 {
+				fingerprint["T1"] = test
+			}
+
+			if ok {
+				ids = append(ids, id)
+			}
+			time.Sleep(osSeqInterval)
+		}
+
+		if len(ids) > 1 {
+			seq["TI"] = ipIdSequence(ids)
+		}
+	}
+
+	var closedIds []int
+
+	for _, probe := range osTcpProbes {
+		port := openPort
+		if probe.closed {
+			port = closedPort
+		}
+
+		// Open port tests are skipped when no port was found open
+		if port == 0 {
+			continue
+		}
+
+		test, id, ok := p.probeTcp(port, probe.flags)
+		fingerprint[probe.name] = test
+
+		if ok && probe.closed {
+			closedIds = append(closedIds, id)
+		}
+	}
+
+	if len(closedIds) > 1 {
+		seq["CI"] = ipIdSequence(closedIds)
+	}
+
+	if len(seq) > 0 {
+		fingerprint["SEQ"] = seq
+	}
+
+	fingerprint["IE"] = p.probeEcho()
+	fingerprint["U1"] = p.probeUdp(osClosedUdpPort)
+
+	return fingerprint
+}
+
+// Function to detect the OS of a host by matching its replies to a set of probes against the signature database
+func OSDetect(target string, ports []utils.Port, timeout time.Duration) []utils.OSMatch {
+	var matches []utils.OSMatch
+
+	fmt.Printf("%s[*] Starting OS detection on host %s%s\n", utils.Blue, target, utils.Reset)
+	fmt.Println(utils.Lines)
+
+	// Pick one open and one closed TCP port from the scan results
+	openPort, closedPort := 0, 0
+	for _, port := range ports {
+		if port.Status == "open" && openPort == 0 {
+			openPort = port.Port
+		}
+		if port.Status == "closed" && closedPort == 0 {
+			closedPort = port.Port
+		}
+	}
+
+	if openPort == 0 {
+		utils.PrintWarning("[!] No open TCP port found, OS detection will be less accurate")
+	}
+
+	if closedPort == 0 {
+		closedPort = unscannedPort(ports)
+	}
+
+	srcIp, err := getSourceIp(target)
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not get local IP: %v", err)))
+		return matches
+	}
+
+	tcpConn, err := openRawConn(layers.IPProtocolTCP)
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not open raw TCP socket: %v", err)))
+		return matches
+	}
+	defer tcpConn.Close()
+
+	icmpConn, err := openRawConn(layers.IPProtocolICMPv4)
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not open raw ICMP socket: %v", err)))
+		return matches
+	}
+	defer icmpConn.Close()
+
+	prober := &osProber{
+		tcpConn:  tcpConn,
+		icmpConn: icmpConn,
+		srcIp:    srcIp,
+		target:   net.ParseIP(target),
+		srcPort:  layers.TCPPort(32768 + rand.Intn(20000)),
+		timeout:  timeout,
+	}
+
+	fingerprint := prober.fingerprint(openPort, closedPort)
+
+	for _, entry := range loadOSEntries() {
+		if accuracy := entry.accuracy(fingerprint); accuracy >= osMinAccuracy {
+			matches = append(matches, utils.OSMatch{Name: entry.name, Accuracy: accuracy, CPE: entry.cpe})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Accuracy > matches[j].Accuracy
+	})
+
+	if len(matches) > osMaxMatches {
+		matches = matches[:osMaxMatches]
+	}
+
+	for _, match := range matches {
+		utils.PrintSuccess(fmt.Sprintf("[+] %s (%d%%) %s", match.Name, match.Accuracy, strings.Join(match.CPE, " ")))
+	}
+
+	// Unknown fingerprints are shown so they can be added to the database
+	if len(matches) == 0 {
+		fmt.Printf("%s[*] No OS matches, fingerprint: %s%s\n", utils.Blue, fingerprint, utils.Reset)
+	}
+
+	fmt.Println(utils.Lines)
+	fmt.Printf("%s[*] OS detection finished on host %s%s\n", utils.Blue, target, utils.Reset)
+
+	return matches
+}
@@ -0,0 +1,81 @@
+package scanner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOSTest(t *testing.T) {
+	name, test, ok := parseOSTest("T1(R=Y%DF=Y%W=FAF0|FFFF%O=M5B4ST11NW7)")
+	if !ok || name != "T1" {
+		t.Fatalf("parseOSTest = %q, %v", name, ok)
+	}
+
+	want := osTest{"R": "Y", "DF": "Y", "W": "FAF0|FFFF", "O": "M5B4ST11NW7"}
+	for attribute, value := range want {
+		if test[attribute] != value {
+			t.Errorf("%s = %q, want %q", attribute, test[attribute], value)
+		}
+	}
+
+	for _, line := range []string{"T1", "T1(R=Y", "CPE cpe:/o:linux"} {
+		if _, _, ok := parseOSTest(line); ok {
+			t.Errorf("parseOSTest(%q) should fail", line)
+		}
+	}
+}
+
+func TestOSEntryAccuracy(t *testing.T) {
+	entry := osEntry{name: "Test OS", tests: osFingerprint{
+		"SEQ": {"TI": "I"},
+		"T1":  {"R": "Y", "DF": "Y", "W": "FAF0|FFFF"},
+		"IE":  {"R": "Y", "CD": "S"},
+	}}
+
+	tests := []struct {
+		name        string
+		fingerprint osFingerprint
+		want        int
+	}{
+		{"every attribute matches", osFingerprint{"SEQ": {"TI": "I"}, "T1": {"R": "Y", "DF": "Y", "W": "FFFF"}, "IE": {"R": "Y", "CD": "S"}}, 100},
+		// TI weighs 100 of the 275 possible
+		{"weighted mismatch", osFingerprint{"SEQ": {"TI": "RD"}, "T1": {"R": "Y", "DF": "Y", "W": "FFFF"}, "IE": {"R": "Y", "CD": "S"}}, 175 * 100 / 275},
+		{"alternatives", osFingerprint{"T1": {"W": "FAF0"}}, 100},
+		{"only common attributes count", osFingerprint{"T1": {"R": "Y", "TTL": "40"}, "U1": {"R": "N"}}, 100},
+		{"unknown attributes weigh 10", osFingerprint{"T1": {"R": "N", "DF": "Y"}}, 20 * 100 / 70},
+		{"nothing in common", osFingerprint{"U1": {"R": "Y"}}, 0},
+	}
+
+	for _, test := range tests {
+		if got := entry.accuracy(test.fingerprint); got != test.want {
+			t.Errorf("%s: accuracy = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBundledOSEntries(t *testing.T) {
+	entries := loadOSEntries()
+	if len(entries) == 0 {
+		t.Fatal("no entries in the bundled database")
+	}
+
+	for _, entry := range entries {
+		if len(entry.tests) == 0 {
+			t.Errorf("%s has no tests", entry.name)
+		}
+
+		// Every entry matches its own signature when each alternative is observed
+		fingerprint := make(osFingerprint)
+		for name, test := range entry.tests {
+			observed := make(osTest)
+			for attribute, expected := range test {
+				observed[attribute], _, _ = strings.Cut(expected, "|")
+			}
+			fingerprint[name] = observed
+		}
+
+		if accuracy := entry.accuracy(fingerprint); accuracy != 100 {
+			t.Errorf("%s matches its own signature at %d%%", entry.name, accuracy)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"gmap/utils"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+)
+
+// Options sent on SYN probes, most stacks only echo back the options they were offered
+var synOptions = []layers.TCPOption{
+	{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}},
+	{OptionType: layers.TCPOptionKindSACKPermitted, OptionLength: 2},
+	{OptionType: layers.TCPOptionKindTimestamps, OptionLength: 10, OptionData: []byte{0, 0, 0, 1, 0, 0, 0, 0}},
+	{OptionType: layers.TCPOptionKindNop, OptionLength: 1},
+	{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{7}},
+}
+
+// Letters used to describe the order of TCP options
+var tcpOptionCodes = map[layers.TCPOptionKind]string{
+	layers.TCPOptionKindEndList:       "E",
+	layers.TCPOptionKindNop:           "N",
+	layers.TCPOptionKindMSS:           "M",
+	layers.TCPOptionKindWindowScale:   "W",
+	layers.TCPOptionKindSACKPermitted: "S",
+	layers.TCPOptionKindTimestamps:    "T",
+}
+
+// Weights of every trait when matching a signature
+const (
+	ttlWeight     = 3
+	optionsWeight = 3
+	windowWeight  = 2
+)
+
+// Traits of the SYN/ACK sent by an OS family
+type osSignature struct {
+	family string
+	// Initial TTL
+	ttl int
+	// Window sizes, either absolute or as a multiple of the MSS
+	windows      []int
+	mssMultiples []int
+	// Option orders, several when the stack depends on version or settings
+	options []string
+}
+
+// Passive signatures for the most common OS families
+var osSignatures = []osSignature{
+	{family: "Linux", ttl: 64, windows: []int{5792, 14480, 28960, 29200, 43440, 64240, 65160}, mssMultiples: []int{4, 10, 20, 44, 45}, options: []string{"MSTNW", "MNNSNW", "MSNW"}},
+	{family: "Windows", ttl: 128, windows: []int{8192, 16384, 64240, 65535}, mssMultiples: []int{44}, options: []string{"MNWNNS", "MNWNNTS", "MNWST"}},
+	{family: "FreeBSD", ttl: 64, windows: []int{65535}, options: []string{"MNWST", "MNWSNNT"}},
+	{family: "macOS", ttl: 64, windows: []int{65535}, options: []string{"MNWNNTSE", "MNWNNTS"}},
+	{family: "OpenBSD", ttl: 64, windows: []int{16384}, options: []string{"MNNSNWNNT"}},
+	{family: "Solaris", ttl: 255, windows: []int{32806, 49232, 64240, 65535}, options: []string{"NNTMNWNNS", "MNWNNTNNS", "M"}},
+	{family: "Cisco IOS", ttl: 255, windows: []int{4128, 8192, 16384}, options: []string{"M"}},
+}
+
+// Auxiliary function to round a received TTL up to the initial TTL set by the sender
+func initialTTL(ttl int) int {
+	for _, initial := range []int{32, 64, 128} {
+		if ttl <= initial {
+			return initial
+		}
+	}
+
+	return 255
+}
+
+// Auxiliary function to extract the traits of a SYN/ACK
+func fingerprintSynAck(ip *layers.IPv4, tcp *layers.TCP) *utils.TCPFingerprint {
+	fingerprint := &utils.TCPFingerprint{
+		TTL:    int(ip.TTL),
+		Window: int(tcp.Window),
+	}
+
+	for _, option := range tcp.Options {
+		if option.OptionType == layers.TCPOptionKindMSS && len(option.OptionData) == 2 {
+			fingerprint.MSS = int(binary.BigEndian.Uint16(option.OptionData))
+		}
+	}
+	fingerprint.Options = tcpOptionOrder(tcp)
+
+	return fingerprint
+}
+
+// Auxiliary function to describe the order of the options of a TCP segment
+func tcpOptionOrder(tcp *layers.TCP) string {
+	var options strings.Builder
+
+	for _, option := range tcp.Options {
+		options.WriteString(tcpOptionCodes[option.OptionType])
+	}
+
+	return options.String()
+}
+
+// Score how well a fingerprint matches a signature, as a percentage
+func (s osSignature) match(fingerprint *utils.TCPFingerprint) int {
+	score := 0
+
+	if initialTTL(fingerprint.TTL) == s.ttl {
+		score += ttlWeight
+	}
+
+	for _, options := range s.options {
+		if fingerprint.Options == options {
+			score += optionsWeight
+			break
+		}
+	}
+
+	windows := s.windows
+	// Some stacks size the window after the MSS they announced
+	for _, multiple := range s.mssMultiples {
+		windows = append(windows[:len(windows):len(windows)], multiple*fingerprint.MSS)
+	}
+
+	for _, window := range windows {
+		if window > 0 && fingerprint.Window == window {
+			score += windowWeight
+			break
+		}
+	}
+
+	return score * 100 / (ttlWeight + optionsWeight + windowWeight)
+}
+
+// Guess the OS family of a host from the SYN/ACKs captured while scanning it
+func GuessOS(ports []utils.Port) *utils.OSGuess {
+	var best *utils.OSGuess
+
+	for _, port := range ports {
+		if port.Fingerprint == nil {
+			continue
+		}
+
+		for _, signature := range osSignatures {
+			confidence := signature.match(port.Fingerprint)
+
+			if best == nil || confidence > best.Confidence {
+				best = &utils.OSGuess{Family: signature.family, Confidence: confidence}
+			}
+		}
+	}
+
+	// A TTL match alone is not enough to tell families apart
+	if best == nil || best.Confidence <= ttlWeight*100/(ttlWeight+optionsWeight+windowWeight) {
+		return nil
+	}
+
+	return best
+}
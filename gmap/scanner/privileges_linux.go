@@ -0,0 +1,17 @@
+//go:build linux
+
+package scanner
+
+import "golang.org/x/sys/unix"
+
+// Check whether the process holds CAP_NET_RAW, needed for raw sockets and packet capture
+func HasRawSockets() bool {
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{}
+
+	if err := unix.Capget(&header, &data[0]); err != nil {
+		return false
+	}
+
+	return data[0].Effective&(1<<unix.CAP_NET_RAW) != 0
+}
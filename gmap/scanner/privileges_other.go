@@ -0,0 +1,10 @@
+//go:build !linux
+
+package scanner
+
+import "os"
+
+// Without capabilities only root is allowed to open raw sockets
+func HasRawSockets() bool {
+	return os.Geteuid() == 0
+}
@@ -0,0 +1,178 @@
+package scanner
+
+import (
+	"fmt"
+	"gmap/utils"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/ipv4"
+)
+
+// Raw socket protocol allowing to send packets of any protocol
+const ipProtocolRaw = layers.IPProtocol(255)
+
+// Collects which protocols the target answered in and the ICMP errors it sent back
+type protoListener struct {
+	handle  *pcap.Handle
+	mutex   sync.Mutex
+	answers map[int]bool
+	codes   map[int]int
+}
+
+// Read packets sent by the target until the capture is closed
+func (l *protoListener) listen() {
+	packetSource := gopacket.NewPacketSource(l.handle, l.handle.LinkType())
+
+	for packet := range packetSource.Packets() {
+		ipLayer, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		if !ok {
+			continue
+		}
+
+		l.mutex.Lock()
+
+		if icmpLayer, ok := packet.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4); ok && icmpLayer.TypeCode.Type() == layers.ICMPv4TypeDestinationUnreachable {
+			// The error quotes the header of the probe it refers to
+			if quoted, err := ipv4.ParseHeader(icmpLayer.Payload); err == nil {
+				l.codes[quoted.Protocol] = int(icmpLayer.TypeCode.Code())
+			}
+		} else {
+			// Any other answer proves the protocol is supported
+			l.answers[int(ipLayer.Protocol)] = true
+		}
+
+		l.mutex.Unlock()
+	}
+}
+
+// Return the state of a protocol based on the replies received
+func (l *protoListener) state(protocol int) string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.answers[protocol] {
+		return "open"
+	}
+
+	if code, ok := l.codes[protocol]; ok {
+		// A port unreachable means the target parsed the transport header
+		if code == icmpPortUnreachable {
+			return "open"
+		}
+
+		if state, ok := unreachableState(code, icmpProtocolUnreachable); ok {
+			return state
+		}
+	}
+
+	return "open/filtered"
+}
+
+// Auxiliary function to build a probe with a valid header for well known protocols
+func protocolPayload(protocol layers.IPProtocol, ipLayer *layers.IPv4) []gopacket.SerializableLayer {
+	switch protocol {
+	case layers.IPProtocolICMPv4:
+		return []gopacket.SerializableLayer{&layers.ICMPv4{
+			TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0),
+			Id:       uint16(time.Now().UnixNano()),
+		}}
+	case layers.IPProtocolTCP:
+		tcpLayer := &layers.TCP{SrcPort: 12345, DstPort: 80, ACK: true, Window: 1024}
+		tcpLayer.SetNetworkLayerForChecksum(ipLayer)
+		return []gopacket.SerializableLayer{tcpLayer}
+	case layers.IPProtocolUDP:
+		udpLayer := &layers.UDP{SrcPort: 12345, DstPort: 40125}
+		udpLayer.SetNetworkLayerForChecksum(ipLayer)
+		return []gopacket.SerializableLayer{udpLayer}
+	case layers.IPProtocolSCTP:
+		return []gopacket.SerializableLayer{
+			&layers.SCTP{SrcPort: 12345, DstPort: 80},
+			&layers.SCTPInit{
+				SCTPChunk:                      layers.SCTPChunk{Type: layers.SCTPChunkTypeInit},
+				InitiateTag:                    1,
+				AdvertisedReceiverWindowCredit: 106496,
+				OutboundStreams:                10,
+				InboundStreams:                 2048,
+			},
+		}
+	case layers.IPProtocolIGMP:
+		// IGMPv2 membership query
+		return []gopacket.SerializableLayer{gopacket.Payload{0x11, 0x64, 0xee, 0x9b, 0x00, 0x00, 0x00, 0x00}}
+	}
+
+	return nil
+}
+
+// IP protocol worker for go routine multithreading
+func protoWorker(scan utils.ScanParameters, protocol int, srcIp net.IP, conn *ipv4.RawConn, listener *protoListener, results chan<- utils.Port, wg *sync.WaitGroup) {
+	// Ensure worker is done
+	defer wg.Done()
+
+	ipLayer := &layers.IPv4{
+		SrcIP:    srcIp,
+		DstIP:    net.ParseIP(scan.Target),
+		Protocol: layers.IPProtocol(protocol),
+	}
+
+	if err := sendRaw(conn, ipLayer, protocolPayload(ipLayer.Protocol, ipLayer)...); err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Failed to send probe for protocol %d: %v", protocol, err)))
+	}
+
+	// Give the target time to answer
+	time.Sleep(scan.Timeout)
+
+	// The protocol number and name take the place of the port
+	results <- utils.Port{Port: protocol, Status: listener.state(protocol), Service: checkService(utils.IPProtocols[protocol])}
+}
+
+// Function to perform an IP protocol Scan
+func ProtocolScan(scan utils.ScanParameters) []utils.Port {
+	var results []utils.Port
+
+	fmt.Printf("%s[*] Starting IP protocol scan on host %s%s\n", utils.Blue, scan.Target, utils.Reset)
+	fmt.Println(utils.Lines)
+
+	srcIp, err := getSourceIp(scan.Target)
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not get local IP: %v", err)))
+		return results
+	}
+
+	conn, err := openRawConn(ipProtocolRaw)
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not open raw socket: %v", err)))
+		return results
+	}
+	defer conn.Close()
+
+	// Capture every packet from the target, whatever its protocol
+	handle, err := openCapture(srcIp, fmt.Sprintf("ip and src host %s", scan.Target))
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not capture replies: %v", err)))
+		return results
+	}
+	defer handle.Close()
+
+	listener := &protoListener{
+		handle:  handle,
+		answers: make(map[int]bool),
+		codes:   make(map[int]int),
+	}
+	go listener.listen()
+
+	// Protocol unreachable errors are rate limited just like port unreachable ones
+	results = pacedProbes(scan.Target, scan.Ports, scan.Delay, func(protocol int, results chan<- utils.Port, wg *sync.WaitGroup) {
+		protoWorker(scan, protocol, srcIp, conn, listener, results, wg)
+	})
+
+	fmt.Println(utils.Lines)
+	fmt.Printf("%s[*] IP protocol Scan finished on host %s%s\n", utils.Blue, scan.Target, utils.Reset)
+	fmt.Printf("%s[*] %d protocols scanned %d up %s\n", utils.Blue, len(scan.Ports), countOpenPorts(results), utils.Reset)
+
+	return results
+}
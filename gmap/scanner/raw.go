@@ -0,0 +1,88 @@
+package scanner
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/ipv4"
+)
+
+// Default TTL for crafted packets
+const defaultTTL = 64
+
+// Auxiliary function to get the local IP used to reach a target
+func getSourceIp(target string) (net.IP, error) {
+	// No packets are sent, dialing UDP only resolves the route
+	conn, err := net.Dial("udp", net.JoinHostPort(target, "9"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.To4(), nil
+}
+
+// Auxiliary function to open a raw IPv4 socket for a protocol where we write the IP header ourselves
+func openRawConn(protocol layers.IPProtocol) (*ipv4.RawConn, error) {
+	conn, err := net.ListenPacket(fmt.Sprintf("ip4:%d", protocol), "0.0.0.0")
+	if err != nil {
+		return nil, err
+	}
+
+	rawConn, err := ipv4.NewRawConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return rawConn, nil
+}
+
+// Auxiliary function to serialize a packet and send it through a raw socket
+func sendRaw(conn *ipv4.RawConn, ipLayer *layers.IPv4, payload ...gopacket.SerializableLayer) error {
+	ipLayer.Version = 4
+	if ipLayer.TTL == 0 {
+		ipLayer.TTL = defaultTTL
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+
+	// Assemble the package
+	if err := gopacket.SerializeLayers(buffer, options, append([]gopacket.SerializableLayer{ipLayer}, payload...)...); err != nil {
+		return err
+	}
+	packet := buffer.Bytes()
+
+	header, err := ipv4.ParseHeader(packet)
+	if err != nil {
+		return err
+	}
+
+	return conn.WriteTo(header, packet[header.Len:], nil)
+}
+
+// Auxiliary function to capture packets matching a BPF filter on the interface owning srcIp
+func openCapture(srcIp net.IP, filter string) (*pcap.Handle, error) {
+	iface, err := getInterface(&srcIp)
+	if err != nil {
+		return nil, err
+	}
+
+	// A short read timeout lets the capture be closed while no packets arrive
+	handle, err := pcap.OpenLive(iface.Name, 65536, true, 100*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := handle.SetBPFFilter(filter); err != nil {
+		handle.Close()
+		return nil, err
+	}
+
+	return handle, nil
+}
@@ -1,18 +1,44 @@
 package scanner
 
 import (
+	"errors"
 	"fmt"
 	"gmap/utils"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/go-ping/ping"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 )
 
+// Default limits of the banners read from open ports
+const (
+	DefaultBannerLength  = 1024
+	DefaultBannerTimeout = 2 * time.Second
+)
+
+// Banners starting with a prefix and containing a word, in uppercase, that name the service of a port as nmap does
+var bannerSignatures = []struct {
+	prefix   string
+	contains string
+	service  string
+}{
+	{"SSH-", "", "ssh"},
+	{"HTTP/", "", "http"},
+	{"RFB ", "", "vnc"},
+	{"+OK", "", "pop3"},
+	{"* OK", "", "imap"},
+	{"* PREAUTH", "", "imap"},
+	{"220", "FTP", "ftp"},
+	{"220", "SMTP", "smtp"},
+	{"-DENIED", "REDIS", "redis"},
+}
+
 // Auxiliary function to get local IP
 func getLocalIp() (*net.IP, error) {
 	// Ping google to check the local ip
@@ -106,7 +132,7 @@ func sendRST(srcIP, dstIP net.IP, srcPort, dstPort layers.TCPPort, iface *net.In
 }
 
 // Syn worker for TCP Syn Scan
-func synWorker(target string, port int, timeout time.Duration, results chan<- utils.Port, wg *sync.WaitGroup) {
+func synWorker(scan utils.ScanParameters, port int, results chan<- utils.Port, wg *sync.WaitGroup) {
 	// Ensure worker is done
 	defer wg.Done()
 
@@ -130,7 +156,7 @@ func synWorker(target string, port int, timeout time.Duration, results chan<- ut
 	defer handle.Close()
 
 	// Set packet parameters
-	dstIp := net.ParseIP(target)
+	dstIp := net.ParseIP(scan.Target)
 	dstPort := layers.TCPPort(port)
 	//! Modifiable src port if necessary -> current 12345
 	srcPort := layers.TCPPort(12345)
@@ -153,6 +179,8 @@ func synWorker(target string, port int, timeout time.Duration, results chan<- ut
 		SYN:     true,
 		Seq:     1105024978,
 		Window:  14600,
+		// Offer the usual options so the SYN/ACK reveals the options ordering of the target
+		Options: synOptions,
 	}
 
 	tcpLayer.SetNetworkLayerForChecksum(ipLayer)
@@ -170,13 +198,16 @@ func synWorker(target string, port int, timeout time.Duration, results chan<- ut
 	}
 
 	// Set Berkeley Packet Filter to obtain TCP Packets of the target on the desired port
-	handle.SetBPFFilter(fmt.Sprintf("tcp and src host %s and src port %d and dst port %d", target, port, srcPort))
+	handle.SetBPFFilter(fmt.Sprintf("tcp and src host %s and src port %d and dst port %d", scan.Target, port, srcPort))
 	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-	timeoutChan := time.After(timeout)
+	timeoutChan := time.After(scan.Timeout)
 
 	var service, state string
+	var banner []byte
+	var fingerprint *utils.TCPFingerprint
 
-	for {
+	// Wait until a response or the timeout decides the state
+	for state == "" {
 		select {
 		case packet := <-packetSource.Packets():
 			// We check the tcp layer
@@ -193,28 +224,31 @@ func synWorker(target string, port int, timeout time.Duration, results chan<- ut
 
 					state = "open"
 
+					// Keep the TTL, window and options for OS guessing
+					if ip, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+						fingerprint = fingerprintSynAck(ip, tcp)
+					}
+
 					// Attempt banner grabbing to determine service
-					address := fmt.Sprintf("%s:%d", target, port)
-					conn, err := net.DialTimeout("tcp", address, timeout)
+					address := net.JoinHostPort(scan.Target, strconv.Itoa(port))
+					conn, err := net.DialTimeout("tcp", address, scan.Timeout)
 					if err == nil {
-						service = bannerGrab(conn)
+						banner = bannerGrab(conn, scan.BannerLength, scan.BannerTimeout)
 						conn.Close()
 					}
 
 				} else if tcp.RST {
 					state = "closed"
-					service = ""
 				}
 			}
 
 		// Case in which we run into a timeout -> filtered
 		case <-timeoutChan:
 			state = "filtered"
-			service = ""
 		}
 	}
-	service = checkService(service)
-	results <- utils.Port{Port: port, Status: state, Service: service}
+	service = checkService(identifyService(banner, utils.CommonServices[port]))
+	results <- utils.Port{Port: port, Status: state, Service: service, Banner: utils.NewBanner(banner), Fingerprint: fingerprint}
 
 }
 
@@ -228,7 +262,7 @@ func SynScan(scan utils.ScanParameters) []utils.Port {
 
 	for _, port := range scan.Ports {
 		wg.Add(1)
-		go synWorker(scan.Target, port, scan.Timeout, resultChan, &wg)
+		go synWorker(scan, port, resultChan, &wg)
 	}
 
 	wg.Wait()
@@ -245,33 +279,6 @@ func SynScan(scan utils.ScanParameters) []utils.Port {
 	return results
 }
 
-// Check Availability of host
-func HostUp(target string, timeout time.Duration) bool {
-	pinger, err := ping.NewPinger(target)
-
-	if err != nil {
-		utils.PrintError(fmt.Sprintf("[ERROR] Failed to create pinger for target %s", target))
-		return false
-	}
-
-	// Establish parameters for pinger
-	pinger.Count = 3
-	pinger.Timeout = timeout
-	pinger.SetPrivileged(true)
-
-	// Block until finished
-	err = pinger.Run()
-
-	if err != nil {
-		utils.PrintError(fmt.Sprintf("[ERROR] Ping failed for target %s", target))
-		return false
-	}
-
-	stats := pinger.Statistics()
-
-	return stats.PacketsRecv > 0
-}
-
 // Auxiliary function to check if service is known
 func checkService(service string) string {
 
@@ -295,33 +302,47 @@ func countOpenPorts(results []utils.Port) int {
 	return count
 }
 
+// Auxiliary function to name the service of a port from its banner, or from the usual service of the port
+func identifyService(banner []byte, common string) string {
+	text := string(banner)
+
+	for _, signature := range bannerSignatures {
+		if strings.HasPrefix(text, signature.prefix) && strings.Contains(strings.ToUpper(text), signature.contains) {
+			return signature.service
+		}
+	}
+
+	return common
+}
+
 // Auxiliary function for TCP banner grab for service detection
-func bannerGrab(conn net.Conn) string {
+func bannerGrab(conn net.Conn, length int, timeout time.Duration) []byte {
 	// Set a short read deadline for banner grabbing
-	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	conn.SetReadDeadline(time.Now().Add(timeout))
 
-	buffer := make([]byte, 1024)
+	buffer := make([]byte, length)
 	n, err := conn.Read(buffer)
 
 	if err != nil {
-		return ""
+		return nil
 	}
 
-	return string(buffer[:n])
+	return buffer[:n]
 }
 
 // TCP Worker for go routine Multithreading
-func tcpWorker(target string, port int, timeout time.Duration, results chan<- utils.Port, wg *sync.WaitGroup) {
+func tcpWorker(scan utils.ScanParameters, port int, results chan<- utils.Port, wg *sync.WaitGroup) {
 	// Defer the call to Done to ensure the WaitGroup counter is decremented when the function completes
 	defer wg.Done()
 
 	// Format address string
-	address := fmt.Sprintf("%s:%d", target, port)
+	address := net.JoinHostPort(scan.Target, strconv.Itoa(port))
 
 	// Try to establish connection
-	conn, err := net.DialTimeout("tcp", address, timeout)
+	conn, err := net.DialTimeout("tcp", address, scan.Timeout)
 
-	var state, service string
+	var state string
+	var banner []byte
 
 	if err != nil {
 		// Check wether the port is closed or filtered based on error
@@ -330,31 +351,30 @@ func tcpWorker(target string, port int, timeout time.Duration, results chan<- ut
 		} else {
 			state = "filtered"
 		}
-		service = utils.CommonServices[port]
 	} else { // If no error, port is opened
 		// We try to check for banner to determien service
-		service = bannerGrab(conn)
+		banner = bannerGrab(conn, scan.BannerLength, scan.BannerTimeout)
 		state = "open"
 		conn.Close()
 	}
 
 	// Check service
-	service = checkService(service)
+	service := checkService(identifyService(banner, utils.CommonServices[port]))
 
 	// Send back the result through results channel
-	results <- utils.Port{Port: port, Status: state, Service: service}
+	results <- utils.Port{Port: port, Status: state, Service: service, Banner: utils.NewBanner(banner)}
 }
 
 // UDP Worker for go routine multithreading
-func udpWorker(target string, port int, timeout time.Duration, results chan<- utils.Port, wg *sync.WaitGroup) {
+func udpWorker(scan utils.ScanParameters, port int, listener *icmpListener, results chan<- utils.Port, wg *sync.WaitGroup) {
 	// Defer the call to Done to ensure the WaitGroup counter is decremented when the function completes
 	defer wg.Done()
 
 	// Format address
-	address := fmt.Sprintf("%s:%d", target, port)
+	address := net.JoinHostPort(scan.Target, strconv.Itoa(port))
 
 	// Try to establish connection
-	conn, err := net.DialTimeout("udp", address, timeout)
+	conn, err := net.DialTimeout("udp", address, scan.Timeout)
 
 	var state, service string
 	// If an error occurs the port is closed
@@ -374,25 +394,36 @@ func udpWorker(target string, port int, timeout time.Duration, results chan<- ut
 	}
 
 	// Set a read timeline for the response
-	conn.SetReadDeadline(time.Now().Add(timeout))
-	buff := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(scan.Timeout))
+	buff := make([]byte, scan.BannerLength)
 	n, err := conn.Read(buff)
 
-	if err != nil {
-		// If no response, port is either opened or filtered
-		service = utils.CommonServices[port]
-		state = "open/filtered"
-	} else {
+	var banner []byte
+	if err == nil {
 		// If there is response, port is opened
-		service = string(buff[:n])
+		banner = buff[:n]
 		state = "open"
+	} else {
+		state = "open/filtered"
+
+		// Connected UDP sockets report an ICMP port unreachable as a refused connection, and the other unreachable codes as routing or permission errors
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			state = "closed"
+		} else if errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EACCES) {
+			state = "filtered"
+		} else if code, ok := lookupUnreachable(listener, layers.IPProtocolUDP, port); ok {
+			// Otherwise rely on the ICMP replies captured for this port
+			if icmpState, ok := unreachableState(code, icmpPortUnreachable); ok {
+				state = icmpState
+			}
+		}
 	}
 
 	// Check service
-	service = checkService(service)
+	service = checkService(identifyService(banner, utils.CommonServices[port]))
 
 	// Send back the result through results channel
-	results <- utils.Port{Port: port, Status: state, Service: service}
+	results <- utils.Port{Port: port, Status: state, Service: service, Banner: utils.NewBanner(banner)}
 }
 
 // Function to perform a basic TCP Scan
@@ -406,7 +437,7 @@ func TcpScan(scan utils.ScanParameters) []utils.Port {
 
 	for _, port := range scan.Ports {
 		wg.Add(1)
-		go tcpWorker(scan.Target, port, scan.Timeout, resultChan, &wg)
+		go tcpWorker(scan, port, resultChan, &wg)
 	}
 
 	wg.Wait()
@@ -425,24 +456,25 @@ func TcpScan(scan utils.ScanParameters) []utils.Port {
 
 // Function to perform an UDP Scan
 func UdpScan(scan utils.ScanParameters) []utils.Port {
-	var results []utils.Port
-	resultChan := make(chan utils.Port, len(scan.Ports))
-	var wg sync.WaitGroup
-
 	fmt.Printf("%s[*] Starting UDP scan on host %s%s\n", utils.Blue, scan.Target, utils.Reset)
 	fmt.Println(utils.Lines)
 
-	for _, port := range scan.Ports {
-		wg.Add(1)
-		go udpWorker(scan.Target, port, scan.Timeout, resultChan, &wg)
+	// Listen for ICMP unreachable replies to tell closed and filtered ports apart
+	var listener *icmpListener
+	if HasRawSockets() {
+		var err error
+		listener, err = newICMPListener(scan.Target)
+		if err != nil {
+			fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not listen for ICMP replies, filtered ports will show as open/filtered: %v", err)))
+		} else {
+			defer listener.close()
+		}
 	}
 
-	wg.Wait()
-	close(resultChan)
-
-	for result := range resultChan {
-		results = append(results, result)
-	}
+	// Space out probes once the target's ICMP rate limit is seen swallowing replies
+	results := pacedProbes(scan.Target, scan.Ports, scan.Delay, func(port int, results chan<- utils.Port, wg *sync.WaitGroup) {
+		udpWorker(scan, port, listener, results, wg)
+	})
 
 	fmt.Println(utils.Lines)
 	fmt.Printf("%s[*] UDP Scan finished on host %s%s\n", utils.Blue, scan.Target, utils.Reset)
@@ -461,7 +493,7 @@ func PerformScan(scan utils.ScanParameters, scanType string) []utils.Port {
 
 	for _, port := range scan.Ports {
 		wg.Add(1)
-		go udpWorker(scan.Target, port, scan.Timeout, resultChan, &wg)
+		go udpWorker(scan, port, nil, resultChan, &wg)
 	}
 
 	wg.Wait()
@@ -0,0 +1,187 @@
+package scanner
+
+import (
+	"fmt"
+	"gmap/utils"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/ipv4"
+)
+
+// SCTP scan techniques, named after the chunk sent as probe
+const (
+	SctpInit       = "init"
+	SctpCookieEcho = "cookie"
+)
+
+// Collects the chunks sent back by the target for every probed port
+type sctpListener struct {
+	conn    *ipv4.RawConn
+	target  net.IP
+	srcPort layers.SCTPPort
+	mutex   sync.Mutex
+	chunks  map[int]gopacket.LayerType
+}
+
+// Read SCTP packets from the target until the socket is closed
+func (l *sctpListener) listen() {
+	buffer := make([]byte, 1500)
+
+	for {
+		header, payload, _, err := l.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+
+		if !header.Src.Equal(l.target) {
+			continue
+		}
+
+		packet := gopacket.NewPacket(payload, layers.LayerTypeSCTP, gopacket.Default)
+		sctpLayer, ok := packet.Layer(layers.LayerTypeSCTP).(*layers.SCTP)
+		if !ok || sctpLayer.DstPort != l.srcPort {
+			continue
+		}
+
+		// Keep only the chunks that decide the state of a port
+		for _, chunk := range []gopacket.LayerType{layers.LayerTypeSCTPInitAck, layers.LayerTypeSCTPAbort} {
+			if packet.Layer(chunk) != nil {
+				l.mutex.Lock()
+				l.chunks[int(sctpLayer.SrcPort)] = chunk
+				l.mutex.Unlock()
+			}
+		}
+	}
+}
+
+// Return the chunk received from a port, if any
+func (l *sctpListener) lookup(port int) (gopacket.LayerType, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	chunk, ok := l.chunks[port]
+	return chunk, ok
+}
+
+// SCTP worker for go routine multithreading
+func sctpWorker(scan utils.ScanParameters, port int, technique string, srcIp net.IP, listener *sctpListener, icmpListener *icmpListener, results chan<- utils.Port, wg *sync.WaitGroup) {
+	// Ensure worker is done
+	defer wg.Done()
+
+	ipLayer := &layers.IPv4{
+		SrcIP:    srcIp,
+		DstIP:    net.ParseIP(scan.Target),
+		Protocol: layers.IPProtocolSCTP,
+	}
+
+	sctpLayer := &layers.SCTP{
+		SrcPort: listener.srcPort,
+		DstPort: layers.SCTPPort(port),
+	}
+
+	var chunk gopacket.SerializableLayer
+
+	switch technique {
+	case SctpInit:
+		// INIT chunks must be sent with a zero verification tag
+		chunk = &layers.SCTPInit{
+			SCTPChunk:                      layers.SCTPChunk{Type: layers.SCTPChunkTypeInit},
+			InitiateTag:                    rand.Uint32() | 1,
+			AdvertisedReceiverWindowCredit: 106496,
+			OutboundStreams:                10,
+			InboundStreams:                 2048,
+			InitialTSN:                     rand.Uint32(),
+		}
+	case SctpCookieEcho:
+		// A bogus cookie is silently dropped by open ports and aborted by closed ones
+		sctpLayer.VerificationTag = rand.Uint32()
+		chunk = &layers.SCTPCookieEcho{
+			SCTPChunk: layers.SCTPChunk{Type: layers.SCTPChunkTypeCookieEcho},
+			Cookie:    []byte{0x00, 0x00, 0x00, 0x00},
+		}
+	}
+
+	if err := sendRaw(listener.conn, ipLayer, sctpLayer, chunk); err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Failed to send SCTP probe to port %d: %v", port, err)))
+	}
+
+	// Give the target time to answer
+	time.Sleep(scan.Timeout)
+
+	var state string
+
+	if reply, ok := listener.lookup(port); ok && reply == layers.LayerTypeSCTPInitAck {
+		state = "open"
+	} else if ok && reply == layers.LayerTypeSCTPAbort {
+		state = "closed"
+	} else if _, ok := lookupUnreachable(icmpListener, layers.IPProtocolSCTP, port); ok {
+		// Any unreachable error means a device in the way dropped the probe
+		state = "filtered"
+	} else if technique == SctpCookieEcho {
+		state = "open/filtered"
+	} else {
+		state = "filtered"
+	}
+
+	results <- utils.Port{Port: port, Status: state, Service: checkService(utils.CommonSctpServices[port])}
+}
+
+// Function to perform an SCTP INIT or COOKIE-ECHO Scan
+func SctpScan(scan utils.ScanParameters, technique string) []utils.Port {
+	var results []utils.Port
+	resultChan := make(chan utils.Port, len(scan.Ports))
+	var wg sync.WaitGroup
+
+	fmt.Printf("%s[*] Starting SCTP %s scan on host %s%s\n", utils.Blue, technique, scan.Target, utils.Reset)
+	fmt.Println(utils.Lines)
+
+	srcIp, err := getSourceIp(scan.Target)
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not get local IP: %v", err)))
+		return results
+	}
+
+	conn, err := openRawConn(layers.IPProtocolSCTP)
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not open raw SCTP socket: %v", err)))
+		return results
+	}
+	defer conn.Close()
+
+	listener := &sctpListener{
+		conn:    conn,
+		target:  net.ParseIP(scan.Target),
+		srcPort: layers.SCTPPort(32768 + rand.Intn(28232)),
+		chunks:  make(map[int]gopacket.LayerType),
+	}
+	go listener.listen()
+
+	// ICMP unreachable replies point to a filtering device
+	icmpListener, err := newICMPListener(scan.Target)
+	if err == nil {
+		defer icmpListener.close()
+	}
+
+	for _, port := range scan.Ports {
+		wg.Add(1)
+		go sctpWorker(scan, port, technique, srcIp, listener, icmpListener, resultChan, &wg)
+	}
+
+	wg.Wait()
+	close(resultChan)
+
+	for result := range resultChan {
+		results = append(results, result)
+	}
+
+	fmt.Println(utils.Lines)
+	fmt.Printf("%s[*] SCTP %s Scan finished on host %s%s\n", utils.Blue, technique, scan.Target, utils.Reset)
+	fmt.Printf("%s[*] %d ports scanned %d up %s\n", utils.Blue, len(scan.Ports), countOpenPorts(results), utils.Reset)
+
+	return results
+}
@@ -0,0 +1,66 @@
+package scanner
+
+import (
+	"fmt"
+	"gmap/utils"
+	"sync"
+	"time"
+)
+
+// Number of hosts discovered at the same time during a ping sweep
+const sweepWorkers = 64
+
+// Function to perform a ping sweep, discovering hosts without scanning their ports
+func PingSweep(targets []string, discovery utils.DiscoveryParameters) []utils.Host {
+	var live []utils.Host
+	hosts := make([]utils.Host, len(targets))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	fmt.Printf("%s[*] Starting ping sweep on %d hosts%s\n", utils.Blue, len(targets), utils.Reset)
+	fmt.Println(utils.Lines)
+
+	start := time.Now()
+
+	for i := 0; i < sweepWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := range jobs {
+				host := DiscoverHost(targets[index], discovery)
+				hosts[index] = host
+
+				if host.Status != "up" {
+					continue
+				}
+
+				line := fmt.Sprintf("[+] %s is up (%s latency, %s)", host.Address, host.Latency.Round(time.Microsecond), host.Method)
+				if host.MAC != "" {
+					line += fmt.Sprintf(" MAC: %s (%s)", host.MAC, host.Vendor)
+				}
+				utils.PrintSuccess(line)
+			}
+		}()
+	}
+
+	for index := range targets {
+		jobs <- index
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	// Keep the live hosts in the order they were given
+	for _, host := range hosts {
+		if host.Status == "up" {
+			live = append(live, host)
+		}
+	}
+
+	fmt.Println(utils.Lines)
+	fmt.Printf("%s[*] Ping sweep finished in %s%s\n", utils.Blue, time.Since(start).Round(time.Millisecond), utils.Reset)
+	fmt.Printf("%s[*] %d hosts scanned %d up %s\n", utils.Blue, len(targets), len(live), utils.Reset)
+
+	return live
+}
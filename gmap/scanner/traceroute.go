@@ -0,0 +1,244 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"fmt"
+	"gmap/utils"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/ipv4"
+)
+
+// Traceroute parameters
+const (
+	traceMaxTTL = 30
+	// Destination port of UDP probes when no open port is known, as in the classic traceroute
+	traceUdpPort = 33434
+)
+
+// Records the hops answering the probes, the TTL of each probe is encoded in its source port
+type traceListener struct {
+	handle   *pcap.Handle
+	target   net.IP
+	basePort int
+	mutex    sync.Mutex
+	sent     map[int]time.Time
+	hops     map[int]utils.Hop
+	// Lowest TTL answered by the target itself
+	destination int
+}
+
+// Read packets until the capture is closed
+func (l *traceListener) listen() {
+	packetSource := gopacket.NewPacketSource(l.handle, l.handle.LinkType())
+
+	for packet := range packetSource.Packets() {
+		ipLayer, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		if !ok {
+			continue
+		}
+
+		srcPort := 0
+
+		if icmpLayer, ok := packet.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4); ok {
+			switch icmpLayer.TypeCode.Type() {
+			case layers.ICMPv4TypeTimeExceeded, layers.ICMPv4TypeDestinationUnreachable:
+			default:
+				continue
+			}
+
+			// The error quotes the header of our probe and the first bytes of its transport header
+			quoted, err := ipv4.ParseHeader(icmpLayer.Payload)
+			if err != nil || !quoted.Dst.Equal(l.target) || len(icmpLayer.Payload) < quoted.Len+2 {
+				continue
+			}
+			srcPort = int(binary.BigEndian.Uint16(icmpLayer.Payload[quoted.Len:]))
+		} else if tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+			srcPort = int(tcp.DstPort)
+		} else if udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+			srcPort = int(udp.DstPort)
+		}
+
+		ttl := srcPort - l.basePort
+		if ttl < 1 || ttl > traceMaxTTL {
+			continue
+		}
+
+		l.mutex.Lock()
+
+		if _, ok := l.hops[ttl]; !ok {
+			l.hops[ttl] = utils.Hop{
+				TTL:     ttl,
+				Address: ipLayer.SrcIP.String(),
+				RTT:     packet.Metadata().Timestamp.Sub(l.sent[ttl]),
+			}
+		}
+
+		if ipLayer.SrcIP.Equal(l.target) && (l.destination == 0 || ttl < l.destination) {
+			l.destination = ttl
+		}
+
+		l.mutex.Unlock()
+	}
+}
+
+// Return the hops in order, up to the target
+func (l *traceListener) path() []utils.Hop {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var hops []utils.Hop
+	for ttl, hop := range l.hops {
+		// Probes with a higher TTL than needed are answered by the target too
+		if l.destination == 0 || ttl <= l.destination {
+			hops = append(hops, hop)
+		}
+	}
+
+	sort.Slice(hops, func(i, j int) bool {
+		return hops[i].TTL < hops[j].TTL
+	})
+
+	return hops
+}
+
+// Auxiliary function to resolve the reverse DNS name of every hop
+func resolveHops(hops []utils.Hop) {
+	var wg sync.WaitGroup
+
+	for i := range hops {
+		wg.Add(1)
+		go func(hop *utils.Hop) {
+			defer wg.Done()
+
+			if names, err := net.LookupAddr(hop.Address); err == nil && len(names) > 0 {
+				hop.Hostname = strings.TrimSuffix(names[0], ".")
+			}
+		}(&hops[i])
+	}
+
+	wg.Wait()
+}
+
+// Function to trace the route to a host, probing an open port from the scan so the target answers the last probe
+func Traceroute(target string, ports []utils.Port, udp bool, timeout time.Duration) []utils.Hop {
+	var hops []utils.Hop
+
+	// Use the first open port, classic UDP traceroute otherwise
+	port := 0
+	for _, result := range ports {
+		if result.Status == "open" {
+			port = result.Port
+			break
+		}
+	}
+
+	if port == 0 {
+		port, udp = traceUdpPort, true
+	}
+
+	protocol := "tcp"
+	if udp {
+		protocol = "udp"
+	}
+
+	fmt.Printf("%s[*] Starting traceroute to host %s (%s/%d)%s\n", utils.Blue, target, protocol, port, utils.Reset)
+	fmt.Println(utils.Lines)
+
+	srcIp, err := getSourceIp(target)
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not get local IP: %v", err)))
+		return hops
+	}
+
+	conn, err := openRawConn(ipProtocolRaw)
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not open raw socket: %v", err)))
+		return hops
+	}
+	defer conn.Close()
+
+	// Capture ICMP errors from routers on the path and replies from the target
+	handle, err := openCapture(srcIp, fmt.Sprintf("dst host %s and (icmp or src host %s)", srcIp, target))
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not capture replies: %v", err)))
+		return hops
+	}
+	defer handle.Close()
+
+	listener := &traceListener{
+		handle:   handle,
+		target:   net.ParseIP(target),
+		basePort: 32768 + rand.Intn(20000),
+		sent:     make(map[int]time.Time),
+		hops:     make(map[int]utils.Hop),
+	}
+
+	// Record the send times before any reply can arrive
+	listener.mutex.Lock()
+	go listener.listen()
+
+	// Every TTL is probed at once, each probe carries its TTL in the source port
+	for ttl := 1; ttl <= traceMaxTTL; ttl++ {
+		ipLayer := &layers.IPv4{
+			SrcIP: srcIp,
+			DstIP: listener.target,
+			TTL:   uint8(ttl),
+		}
+
+		srcPort := listener.basePort + ttl
+		var payload gopacket.SerializableLayer
+
+		if udp {
+			ipLayer.Protocol = layers.IPProtocolUDP
+			udpLayer := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(port)}
+			udpLayer.SetNetworkLayerForChecksum(ipLayer)
+			payload = udpLayer
+		} else {
+			ipLayer.Protocol = layers.IPProtocolTCP
+			tcpLayer := &layers.TCP{SrcPort: layers.TCPPort(srcPort), DstPort: layers.TCPPort(port), SYN: true, Seq: rand.Uint32(), Window: 1024}
+			tcpLayer.SetNetworkLayerForChecksum(ipLayer)
+			payload = tcpLayer
+		}
+
+		listener.sent[ttl] = time.Now()
+		if err := sendRaw(conn, ipLayer, payload); err != nil {
+			fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Failed to send probe with TTL %d: %v", ttl, err)))
+		}
+	}
+	listener.mutex.Unlock()
+
+	// Give every hop time to answer
+	time.Sleep(timeout)
+
+	hops = listener.path()
+	resolveHops(hops)
+
+	// Hops that did not answer are shown as gaps
+	next := 1
+	for _, hop := range hops {
+		for ; next < hop.TTL; next++ {
+			fmt.Printf("  %2d  *\n", next)
+		}
+		next = hop.TTL + 1
+
+		name := hop.Address
+		if hop.Hostname != "" {
+			name = fmt.Sprintf("%s (%s)", hop.Hostname, hop.Address)
+		}
+		fmt.Printf("  %2d  %-10s  %s\n", hop.TTL, hop.RTT.Round(10*time.Microsecond), name)
+	}
+
+	fmt.Println(utils.Lines)
+	fmt.Printf("%s[*] Traceroute finished on host %s, %d hops%s\n", utils.Blue, target, len(hops), utils.Reset)
+
+	return hops
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gmap/utils"
+	"io"
+	"math/rand"
+	"strings"
+)
+
+// Kafka request parameters
+const (
+	kafkaMetadata    = 3
+	kafkaApiVersions = 18
+	// Metadata version whose response carries the cluster ID
+	kafkaMetadataVersion = 2
+	kafkaClientID        = "gmap"
+)
+
+// MQTT packet types and parameters
+const (
+	mqttConnect   = 0x10
+	mqttConnAck   = 0x20
+	mqttPublish   = 0x30
+	mqttSubscribe = 0x82
+	// MQTT 3.1.1
+	mqttProtocolLevel = 4
+	// Topic where Mosquitto and other brokers publish their version
+	mqttVersionTopic = "$SYS/broker/version"
+)
+
+// Return codes of MQTT connection refusals
+var mqttRefusals = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// Reader of the fields of a Kafka response, keeping the first error
+type kafkaReader struct {
+	data []byte
+	err  error
+}
+
+// Auxiliary function to take the next bytes of a response
+func (r *kafkaReader) next(size int) []byte {
+	if r.err != nil || size < 0 || size > len(r.data) {
+		r.err = errors.New("truncated response")
+		return make([]byte, max(size, 0))
+	}
+
+	field := r.data[:size]
+	r.data = r.data[size:]
+
+	return field
+}
+
+func (r *kafkaReader) int16() int16 {
+	return int16(binary.BigEndian.Uint16(r.next(2)))
+}
+
+func (r *kafkaReader) int32() int32 {
+	return int32(binary.BigEndian.Uint32(r.next(4)))
+}
+
+// Auxiliary function to read a string, empty when null
+func (r *kafkaReader) string() string {
+	length := r.int16()
+	if length < 0 {
+		return ""
+	}
+
+	return string(r.next(int(length)))
+}
+
+// Send a Kafka request and return the body of its response
+func kafkaRequest(conn io.ReadWriter, apiKey int16, apiVersion int16, correlation int32, body []byte) (*kafkaReader, error) {
+	request := binary.BigEndian.AppendUint16(nil, uint16(apiKey))
+	request = binary.BigEndian.AppendUint16(request, uint16(apiVersion))
+	request = binary.BigEndian.AppendUint32(request, uint32(correlation))
+	request = binary.BigEndian.AppendUint16(request, uint16(len(kafkaClientID)))
+	request = append(append(request, kafkaClientID...), body...)
+
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(request))), request...)); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+
+	length := int(binary.BigEndian.Uint32(header))
+	if length < 4 || length > maxResponseSize*4 || int32(binary.BigEndian.Uint32(header[4:])) != correlation {
+		return nil, errors.New("invalid response")
+	}
+
+	response := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+
+	return &kafkaReader{data: response}, nil
+}
+
+// Confirm a Kafka broker with an ApiVersions request, then ask for the cluster metadata, refused before SASL authentication
+func enumerateKafka(address string, port *utils.Port, scan utils.ScanParameters) {
+	useTLS := speaksTLS(address, port, scan.Timeout)
+
+	conn, err := dialDatabase(address, useTLS, scan.Timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	versions, err := kafkaRequest(conn, kafkaApiVersions, 0, 1, nil)
+	if err != nil {
+		return
+	}
+
+	errorCode := versions.int16()
+	apis := versions.int32()
+	if versions.err != nil || errorCode != 0 || apis <= 0 {
+		return
+	}
+
+	info := &utils.DatabaseInfo{Product: "Apache Kafka", AuthRequired: true, TLS: useTLS}
+	port.Database = info
+
+	// All topics
+	metadata, err := kafkaRequest(conn, kafkaMetadata, kafkaMetadataVersion, 2, binary.BigEndian.AppendUint32(nil, 0xffffffff))
+	if err != nil {
+		return
+	}
+
+	brokers := metadata.int32()
+	for i := int32(0); i < brokers && metadata.err == nil; i++ {
+		metadata.int32()
+		metadata.string()
+		metadata.int32()
+		metadata.string()
+	}
+
+	info.ClusterName = metadata.string()
+	metadata.int32()
+	topics := metadata.int32()
+
+	if metadata.err == nil {
+		info.AuthRequired = false
+		info.Info = fmt.Sprintf("%d brokers, %d topics", brokers, topics)
+	}
+}
+
+// Auxiliary function to read an MQTT packet, its remaining length is a variable byte integer
+func readMQTTPacket(reader *bufio.Reader) (byte, []byte, error) {
+	kind, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := 0
+	for shift := 0; shift < 28; shift += 7 {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	if length > maxResponseSize {
+		return 0, nil, errors.New("packet too long")
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(reader, payload)
+
+	return kind, payload, err
+}
+
+// Auxiliary function to append an MQTT string, prefixed by its length
+func appendMQTTString(packet []byte, value string) []byte {
+	return append(binary.BigEndian.AppendUint16(packet, uint16(len(value))), value...)
+}
+
+// Connect to an MQTT broker without credentials, and when accepted read the version published on its system topic
+func enumerateMQTT(address string, port *utils.Port, scan utils.ScanParameters) {
+	useTLS := speaksTLS(address, port, scan.Timeout)
+
+	conn, err := dialDatabase(address, useTLS, scan.Timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Clean session, no credentials and a keep alive of a minute
+	connect := appendMQTTString(nil, "MQTT")
+	connect = append(connect, mqttProtocolLevel, 0x02, 0, 60)
+	connect = appendMQTTString(connect, fmt.Sprintf("gmap%d", rand.Intn(100000)))
+
+	if _, err := conn.Write(append([]byte{mqttConnect, byte(len(connect))}, connect...)); err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+	kind, payload, err := readMQTTPacket(reader)
+	if err != nil || kind != mqttConnAck || len(payload) < 2 {
+		return
+	}
+
+	info := &utils.DatabaseInfo{Product: "MQTT", TLS: useTLS}
+	port.Database = info
+
+	if code := payload[1]; code != 0 {
+		info.AuthRequired = code == 4 || code == 5
+		info.Info = mqttRefusals[code]
+		return
+	}
+
+	subscribe := appendMQTTString([]byte{0, 1}, mqttVersionTopic)
+	subscribe = append(subscribe, 0)
+
+	if _, err := conn.Write(append([]byte{mqttSubscribe, byte(len(subscribe))}, subscribe...)); err != nil {
+		return
+	}
+
+	// The retained version is published right after the subscription is acknowledged
+	for i := 0; i < 4; i++ {
+		kind, payload, err := readMQTTPacket(reader)
+		if err != nil {
+			return
+		}
+
+		if kind&0xf0 != mqttPublish || len(payload) < 2 {
+			continue
+		}
+
+		topicLength := int(binary.BigEndian.Uint16(payload))
+		if 2+topicLength > len(payload) || string(payload[2:2+topicLength]) != mqttVersionTopic {
+			continue
+		}
+
+		// Messages of QoS 0 have no packet identifier
+		version := strings.TrimSpace(string(payload[2+topicLength:]))
+		if product, number, found := strings.Cut(version, " version "); found {
+			info.Product = product
+			version = number
+		}
+		info.Version = version
+		return
+	}
+}
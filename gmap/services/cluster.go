@@ -0,0 +1,114 @@
+package services
+
+import (
+	"encoding/json"
+	"gmap/utils"
+	"net/http"
+	"strings"
+)
+
+// Hadoop NameNode information bean, readable from the web interface
+const hadoopNameNodeInfo = "/jmx?qry=Hadoop:service=NameNode,name=NameNodeInfo"
+
+// Read the welcome document of CouchDB for its version, then list the databases, refused to anonymous clients since 3.0
+func enumerateCouchDB(address string, port *utils.Port, scan utils.ScanParameters) {
+	base, useTLS := baseURL(address, port, scan.Timeout)
+	client := httpClient(scan.Timeout)
+
+	response, body, err := httpGet(client, base+"/", maxPageSize)
+	if err != nil || response.StatusCode != http.StatusOK {
+		return
+	}
+
+	var welcome struct {
+		CouchDB string `json:"couchdb"`
+		Version string `json:"version"`
+		Vendor  struct {
+			Name string `json:"name"`
+		} `json:"vendor"`
+	}
+
+	if err := json.Unmarshal(body, &welcome); err != nil || welcome.CouchDB == "" {
+		return
+	}
+
+	info := &utils.DatabaseInfo{Product: "CouchDB", Version: welcome.Version, AuthRequired: true, TLS: useTLS, Info: welcome.Vendor.Name}
+	port.Database = info
+
+	if response, _, err := httpGet(client, base+"/_all_dbs", maxPageSize); err == nil && response.StatusCode == http.StatusOK {
+		info.AuthRequired = false
+	}
+}
+
+// Read the NameNode information of Hadoop, protected by Kerberos (SPNEGO) when security is enabled
+func enumerateHadoop(address string, port *utils.Port, scan utils.ScanParameters) {
+	base, useTLS := baseURL(address, port, scan.Timeout)
+
+	response, body, err := httpGet(httpClient(scan.Timeout), base+hadoopNameNodeInfo, maxPageSize)
+	if err != nil {
+		return
+	}
+
+	info := &utils.DatabaseInfo{Product: "Hadoop HDFS", TLS: useTLS}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		var jmx struct {
+			Beans []struct {
+				Version   string `json:"Version"`
+				ClusterID string `json:"ClusterId"`
+				Safemode  string `json:"Safemode"`
+			} `json:"beans"`
+		}
+
+		if err := json.Unmarshal(body, &jmx); err != nil || len(jmx.Beans) == 0 {
+			return
+		}
+
+		// Versions are followed by the revision they were built from, e.g., 3.3.6, r1be78238...
+		info.Version, _, _ = strings.Cut(jmx.Beans[0].Version, ",")
+		info.ClusterName = jmx.Beans[0].ClusterID
+		info.Info = jmx.Beans[0].Safemode
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if !strings.Contains(response.Header.Get("WWW-Authenticate"), "Negotiate") {
+			return
+		}
+		info.AuthRequired = true
+	default:
+		return
+	}
+
+	port.Database = info
+}
+
+// Ping InfluxDB for its version and build, then run a query needing credentials when authentication is enabled
+func enumerateInfluxDB(address string, port *utils.Port, scan utils.ScanParameters) {
+	base, useTLS := baseURL(address, port, scan.Timeout)
+	client := httpClient(scan.Timeout)
+
+	response, _, err := httpGet(client, base+"/ping", maxPageSize)
+	if err != nil || response.StatusCode != http.StatusNoContent {
+		return
+	}
+
+	version := response.Header.Get("X-Influxdb-Version")
+	if version == "" {
+		return
+	}
+
+	info := &utils.DatabaseInfo{Product: "InfluxDB", Version: strings.TrimPrefix(version, "v"), AuthRequired: true, TLS: useTLS}
+	if build := response.Header.Get("X-Influxdb-Build"); build != "" {
+		info.Info = build + " build"
+	}
+	port.Database = info
+
+	// InfluxDB 2 always needs a token, the query API of InfluxDB 1 only when auth-enabled is set
+	check := "/query?q=SHOW+DATABASES"
+	if strings.HasPrefix(info.Version, "2.") {
+		check = "/api/v2/buckets"
+	}
+
+	if response, _, err := httpGet(client, base+check, maxPageSize); err == nil && response.StatusCode == http.StatusOK {
+		info.AuthRequired = false
+	}
+}
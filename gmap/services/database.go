@@ -0,0 +1,463 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gmap/utils"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Database handshake parameters
+const (
+	mysqlClientSSL         = 0x00000800
+	mysqlClientProtocol41  = 0x00000200
+	mysqlClientSecureConn  = 0x00008000
+	mysqlClientPluginAuth  = 0x00080000
+	mysqlClientLongPass    = 0x00000001
+	postgresSSLRequest     = 80877103
+	postgresProtocol3      = 196608
+	tdsPrelogin            = 0x12
+	tdsEncryptNotSupported = 0x02
+	// Users tried without a password on databases with a default superuser
+	mysqlDefaultUser    = "root"
+	postgresDefaultUser = "postgres"
+)
+
+// Auxiliary function to open a connection, wrapped in TLS when asked
+func dialDatabase(address string, useTLS bool, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout + probeReadTimeout))
+
+	if useTLS {
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+
+	return conn, nil
+}
+
+// Auxiliary function to check if a port speaks TLS right after connecting
+func speaksTLS(address string, port *utils.Port, timeout time.Duration) bool {
+	if port.TLS != nil {
+		return true
+	}
+
+	_, err := tlsHandshake(address, tls.VersionTLS10, tls.VersionTLS13, timeout)
+	return err == nil
+}
+
+// Auxiliary function to get the URL of a service spoken over HTTP, and whether it is HTTPS
+func baseURL(address string, port *utils.Port, timeout time.Duration) (string, bool) {
+	if speaksTLS(address, port, timeout) {
+		return "https://" + address, true
+	}
+
+	return "http://" + address, false
+}
+
+// Auxiliary function to read a MySQL packet
+func readMySQLPacket(reader io.Reader) ([]byte, byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, 0, err
+	}
+
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	payload := make([]byte, length)
+	_, err := io.ReadFull(reader, payload)
+
+	return payload, header[3], err
+}
+
+// Auxiliary function to write a MySQL packet
+func writeMySQLPacket(writer io.Writer, payload []byte, sequence byte) error {
+	packet := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), sequence}
+	_, err := writer.Write(append(packet, payload...))
+	return err
+}
+
+// Read the MySQL greeting for the version and TLS capability, then log in as root without a password
+func enumerateMySQL(address string, port *utils.Port, scan utils.ScanParameters) {
+	conn, err := dialDatabase(address, false, scan.Timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	greeting, sequence, err := readMySQLPacket(conn)
+	if err != nil || len(greeting) == 0 {
+		return
+	}
+
+	info := &utils.DatabaseInfo{Product: "MySQL", AuthRequired: true}
+
+	// Clients not allowed to connect get an error instead of the greeting
+	if greeting[0] == 0xff {
+		if len(greeting) > 3 {
+			info.Info = strings.TrimSpace(string(greeting[3:]))
+		}
+		port.Database = info
+		return
+	}
+
+	version, rest, found := bytes.Cut(greeting[1:], []byte{0})
+	if !found || len(rest) < 4+8+1+2 {
+		return
+	}
+
+	info.Version = string(version)
+	if strings.Contains(strings.ToLower(info.Version), "mariadb") {
+		info.Product = "MariaDB"
+		info.Version = strings.TrimPrefix(info.Version, "5.5.5-")
+	}
+
+	capabilities := uint32(binary.LittleEndian.Uint16(rest[13:]))
+	if len(rest) >= 20 {
+		capabilities |= uint32(binary.LittleEndian.Uint16(rest[18:])) << 16
+	}
+	info.TLS = capabilities&mysqlClientSSL != 0
+
+	// Handshake response with an empty authentication response, accepted only when root has no password
+	response := binary.LittleEndian.AppendUint32(nil, mysqlClientLongPass|mysqlClientProtocol41|mysqlClientSecureConn|mysqlClientPluginAuth)
+	response = binary.LittleEndian.AppendUint32(response, 1<<24)
+	// utf8mb4 and reserved bytes
+	response = append(response, 45)
+	response = append(response, make([]byte, 23)...)
+	response = append(response, mysqlDefaultUser+"\x00"...)
+	response = append(response, 0)
+	response = append(response, "mysql_native_password\x00"...)
+
+	if err := writeMySQLPacket(conn, response, sequence+1); err == nil {
+		reply, sequence, err := readMySQLPacket(conn)
+
+		// Servers defaulting to another plugin ask to switch to it, the empty password is sent again
+		if err == nil && len(reply) > 0 && reply[0] == 0xfe {
+			if err = writeMySQLPacket(conn, nil, sequence+1); err == nil {
+				reply, _, err = readMySQLPacket(conn)
+			}
+		}
+
+		if err == nil && len(reply) > 0 && reply[0] == 0x00 {
+			info.AuthRequired = false
+		}
+	}
+
+	port.Database = info
+}
+
+// Auxiliary function to read a PostgreSQL message
+func readPostgresMessage(reader io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+	if length < 4 || length > maxResponseSize {
+		return 0, nil, errors.New("invalid message length")
+	}
+
+	body := make([]byte, length-4)
+	_, err := io.ReadFull(reader, body)
+
+	return header[0], body, err
+}
+
+// Ask PostgreSQL for TLS, then start a session as postgres to learn whether trust authentication is allowed
+func enumeratePostgres(address string, port *utils.Port, scan utils.ScanParameters) {
+	conn, err := dialDatabase(address, false, scan.Timeout)
+	if err != nil {
+		return
+	}
+
+	request := binary.BigEndian.AppendUint32(nil, 8)
+	request = binary.BigEndian.AppendUint32(request, postgresSSLRequest)
+	answer := make([]byte, 1)
+
+	if _, err := conn.Write(request); err != nil {
+		conn.Close()
+		return
+	}
+
+	if _, err := io.ReadFull(conn, answer); err != nil || (answer[0] != 'S' && answer[0] != 'N') {
+		conn.Close()
+		return
+	}
+	conn.Close()
+
+	info := &utils.DatabaseInfo{Product: "PostgreSQL", AuthRequired: true, TLS: answer[0] == 'S'}
+	port.Database = info
+
+	if conn, err = dialDatabase(address, false, scan.Timeout); err != nil {
+		return
+	}
+	defer conn.Close()
+
+	parameters := []byte("user\x00" + postgresDefaultUser + "\x00database\x00postgres\x00\x00")
+	startup := binary.BigEndian.AppendUint32(nil, uint32(8+len(parameters)))
+	startup = binary.BigEndian.AppendUint32(startup, postgresProtocol3)
+
+	if _, err := conn.Write(append(startup, parameters...)); err != nil {
+		return
+	}
+
+	for {
+		kind, body, err := readPostgresMessage(conn)
+		if err != nil {
+			return
+		}
+
+		switch kind {
+		case 'R':
+			// Any authentication request but AuthenticationOk asks for credentials
+			if len(body) < 4 || binary.BigEndian.Uint32(body) != 0 {
+				return
+			}
+			info.AuthRequired = false
+		case 'S':
+			// The version is only sent once the session is authenticated
+			if name, value, found := bytes.Cut(body, []byte{0}); found && string(name) == "server_version" {
+				info.Version = string(bytes.TrimRight(value, "\x00"))
+			}
+		case 'E':
+			// Error fields are a type byte and a string, the message has type M
+			for _, field := range bytes.Split(body, []byte{0}) {
+				if len(field) > 1 && field[0] == 'M' {
+					info.Info = string(field[1:])
+				}
+			}
+			return
+		case 'Z':
+			return
+		}
+	}
+}
+
+// Send a TDS PRELOGIN to MSSQL for its version and encryption support
+func enumerateMSSQL(address string, port *utils.Port, scan utils.ScanParameters) {
+	conn, err := dialDatabase(address, false, scan.Timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Option tokens (version, encryption, instance, thread ID, MARS) pointing to their data
+	options := []byte{
+		0x00, 0x00, 0x1a, 0x00, 0x06,
+		0x01, 0x00, 0x20, 0x00, 0x01,
+		0x02, 0x00, 0x21, 0x00, 0x01,
+		0x03, 0x00, 0x22, 0x00, 0x04,
+		0x04, 0x00, 0x26, 0x00, 0x01,
+		0xff,
+		// Client version, encryption off, default instance, thread ID and no MARS
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00,
+		0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00,
+	}
+
+	header := []byte{tdsPrelogin, 0x01, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(header)+len(options)))
+
+	if _, err := conn.Write(append(header, options...)); err != nil {
+		return
+	}
+
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[0] != 0x04 {
+		return
+	}
+
+	length := int(binary.BigEndian.Uint16(reply[2:]))
+	if length < 8 {
+		return
+	}
+
+	body := make([]byte, length-8)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return
+	}
+
+	// Logins always need SQL or Windows credentials
+	info := &utils.DatabaseInfo{Product: "Microsoft SQL Server", AuthRequired: true}
+
+	for offset := 0; offset+5 <= len(body) && body[offset] != 0xff; offset += 5 {
+		token := body[offset]
+		position := int(binary.BigEndian.Uint16(body[offset+1:]))
+		size := int(binary.BigEndian.Uint16(body[offset+3:]))
+		if position+size > len(body) {
+			break
+		}
+		data := body[position : position+size]
+
+		switch {
+		case token == 0x00 && size >= 6:
+			info.Version = fmt.Sprintf("%d.%d.%d.%d", data[0], data[1], binary.BigEndian.Uint16(data[2:]), binary.BigEndian.Uint16(data[4:]))
+		case token == 0x01 && size >= 1:
+			info.TLS = data[0] != tdsEncryptNotSupported
+		}
+	}
+
+	port.Database = info
+}
+
+// Request the server section of INFO, answered only without authentication or once authenticated
+func enumerateRedis(address string, port *utils.Port, scan utils.ScanParameters) {
+	useTLS := speaksTLS(address, port, scan.Timeout)
+
+	conn, err := dialDatabase(address, useTLS, scan.Timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("INFO server\r\n")); err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	if err != nil || len(status) == 0 {
+		return
+	}
+
+	info := &utils.DatabaseInfo{Product: "Redis", TLS: useTLS}
+
+	switch status[0] {
+	case '$':
+		length, err := strconv.Atoi(strings.TrimSpace(status[1:]))
+		if err != nil || length < 0 || length > maxResponseSize {
+			return
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return
+		}
+
+		for _, line := range strings.Split(string(body), "\r\n") {
+			if version, found := strings.CutPrefix(line, "redis_version:"); found {
+				info.Version = version
+			}
+			if mode, found := strings.CutPrefix(line, "redis_mode:"); found {
+				info.Info = "mode " + mode
+			}
+		}
+	case '-':
+		// NOAUTH when a password is set, DENIED when protected mode refuses remote clients
+		info.AuthRequired = true
+		info.Info = strings.TrimSpace(status[1:])
+	default:
+		return
+	}
+
+	port.Database = info
+}
+
+// Request the statistics of Memcached, refused when SASL or an authentication file is enabled
+func enumerateMemcached(address string, port *utils.Port, scan utils.ScanParameters) {
+	useTLS := speaksTLS(address, port, scan.Timeout)
+
+	conn, err := dialDatabase(address, useTLS, scan.Timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("stats\r\n")); err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+	info := &utils.DatabaseInfo{Product: "Memcached", TLS: useTLS}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+
+		line = strings.TrimSpace(line)
+		if version, found := strings.CutPrefix(line, "STAT version "); found {
+			info.Version = version
+		}
+
+		if line == "END" {
+			break
+		}
+
+		// ERROR when SASL disables the text protocol, CLIENT_ERROR when an authentication file is used
+		if strings.Contains(line, "ERROR") {
+			info.AuthRequired = true
+			info.Info = line
+			break
+		}
+	}
+
+	if info.Version == "" && !info.AuthRequired {
+		return
+	}
+
+	port.Database = info
+}
+
+// Request the root endpoint of Elasticsearch for its version and cluster name
+func enumerateElasticsearch(address string, port *utils.Port, scan utils.ScanParameters) {
+	base, useTLS := baseURL(address, port, scan.Timeout)
+
+	response, body, err := httpGet(httpClient(scan.Timeout), base+"/", maxPageSize)
+	if err != nil {
+		return
+	}
+
+	info := &utils.DatabaseInfo{Product: "Elasticsearch", TLS: useTLS}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		var root struct {
+			ClusterName string `json:"cluster_name"`
+			Version     struct {
+				Number       string `json:"number"`
+				Distribution string `json:"distribution"`
+			} `json:"version"`
+		}
+
+		if err := json.Unmarshal(body, &root); err != nil || root.Version.Number == "" {
+			return
+		}
+
+		info.Version = root.Version.Number
+		info.ClusterName = root.ClusterName
+		if root.Version.Distribution == "opensearch" {
+			info.Product = "OpenSearch"
+		}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		// Elasticsearch and OpenSearch security answer with their own realm
+		if !strings.Contains(strings.ToLower(response.Header.Get("WWW-Authenticate")), "security") {
+			return
+		}
+		info.AuthRequired = true
+	default:
+		return
+	}
+
+	port.Database = info
+}
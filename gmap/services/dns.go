@@ -0,0 +1,284 @@
+package services
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gmap/utils"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNS enumeration parameters
+const (
+	// Name resolved to check recursion, any server answering it recurses for others
+	dnsRecursionName = "example.com."
+	dnsPayloadSize   = 4096
+	// Record types not named by dnsmessage
+	dnsTypeRRSIG  dnsmessage.Type = 46
+	dnsTypeDNSKEY dnsmessage.Type = 48
+	// Records kept from a zone transfer
+	maxZoneRecords = 10000
+)
+
+// Auxiliary function to build a query, with an EDNS OPT record when asked
+func dnsQuery(name string, qtype dnsmessage.Type, class dnsmessage.Class, recursion bool, edns bool, dnssec bool) (dnsmessage.Message, error) {
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: uint16(rand.Intn(0x10000)), RecursionDesired: recursion},
+	}
+
+	question, err := dnsmessage.NewName(name)
+	if err != nil {
+		return query, err
+	}
+	query.Questions = []dnsmessage.Question{{Name: question, Type: qtype, Class: class}}
+
+	if edns {
+		opt := dnsmessage.Resource{Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(".")}, Body: &dnsmessage.OPTResource{}}
+		if err := opt.Header.SetEDNS0(dnsPayloadSize, dnsmessage.RCodeSuccess, dnssec); err != nil {
+			return query, err
+		}
+		query.Additionals = []dnsmessage.Resource{opt}
+	}
+
+	return query, nil
+}
+
+// Auxiliary function to write a message over TCP, prefixed by its length
+func writeDNSTcp(conn net.Conn, packed []byte) error {
+	_, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(packed))), packed...))
+	return err
+}
+
+// Auxiliary function to read a message over TCP
+func readDNSTcp(conn net.Conn) (dnsmessage.Message, error) {
+	var response dnsmessage.Message
+
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return response, err
+	}
+
+	packed := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(conn, packed); err != nil {
+		return response, err
+	}
+
+	err := response.Unpack(packed)
+	return response, err
+}
+
+// Send a query over UDP or TCP and read the response
+func exchangeDNS(address string, protocol string, query dnsmessage.Message, timeout time.Duration) (dnsmessage.Message, error) {
+	var response dnsmessage.Message
+
+	packed, err := query.Pack()
+	if err != nil {
+		return response, err
+	}
+
+	conn, err := net.DialTimeout(protocol, address, timeout)
+	if err != nil {
+		return response, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout + probeReadTimeout))
+
+	if protocol == "tcp" {
+		if err := writeDNSTcp(conn, packed); err != nil {
+			return response, err
+		}
+
+		if response, err = readDNSTcp(conn); err != nil {
+			return response, err
+		}
+	} else {
+		if _, err := conn.Write(packed); err != nil {
+			return response, err
+		}
+
+		buffer := make([]byte, dnsPayloadSize)
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return response, err
+		}
+
+		if err := response.Unpack(buffer[:n]); err != nil {
+			return response, err
+		}
+	}
+
+	if response.ID != query.ID {
+		return response, errors.New("response to another query")
+	}
+
+	return response, nil
+}
+
+// Auxiliary function to get the first TXT string of a CHAOS query
+func chaosTXT(address string, protocol string, name string, timeout time.Duration) string {
+	query, err := dnsQuery(name, dnsmessage.TypeTXT, dnsmessage.ClassCHAOS, false, false, false)
+	if err != nil {
+		return ""
+	}
+
+	response, err := exchangeDNS(address, protocol, query, timeout)
+	if err != nil {
+		return ""
+	}
+
+	for _, answer := range response.Answers {
+		if txt, ok := answer.Body.(*dnsmessage.TXTResource); ok && len(txt.TXT) > 0 {
+			return strings.Join(txt.TXT, " ")
+		}
+	}
+
+	return ""
+}
+
+// Auxiliary function to describe a record as in a zone file
+func formatRecord(record dnsmessage.Resource) string {
+	var data string
+
+	switch body := record.Body.(type) {
+	case *dnsmessage.AResource:
+		data = net.IP(body.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		data = net.IP(body.AAAA[:]).String()
+	case *dnsmessage.NSResource:
+		data = body.NS.String()
+	case *dnsmessage.CNAMEResource:
+		data = body.CNAME.String()
+	case *dnsmessage.PTRResource:
+		data = body.PTR.String()
+	case *dnsmessage.MXResource:
+		data = fmt.Sprintf("%d %s", body.Pref, body.MX)
+	case *dnsmessage.SRVResource:
+		data = fmt.Sprintf("%d %d %d %s", body.Priority, body.Weight, body.Port, body.Target)
+	case *dnsmessage.TXTResource:
+		data = fmt.Sprintf("%q", strings.Join(body.TXT, ""))
+	case *dnsmessage.SOAResource:
+		data = fmt.Sprintf("%s %s %d", body.NS, body.MBox, body.Serial)
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%s %d %s %s", record.Header.Name, record.Header.TTL, strings.TrimPrefix(record.Header.Type.String(), "Type"), data))
+}
+
+// Request a transfer of a zone over TCP, the zone ends with the same SOA record it starts with
+func transferZone(address string, domain string, timeout time.Duration) ([]string, error) {
+	query, err := dnsQuery(domain, dnsmessage.TypeAXFR, dnsmessage.ClassINET, false, false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout + 10*probeReadTimeout))
+
+	if err := writeDNSTcp(conn, packed); err != nil {
+		return nil, err
+	}
+
+	var records []string
+	soas := 0
+
+	for soas < 2 {
+		response, err := readDNSTcp(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		if response.RCode != dnsmessage.RCodeSuccess || len(response.Answers) == 0 {
+			return nil, fmt.Errorf("transfer refused: %s", response.RCode)
+		}
+
+		for _, answer := range response.Answers {
+			if answer.Header.Type == dnsmessage.TypeSOA {
+				soas++
+			}
+
+			if len(records) < maxZoneRecords && soas < 2 {
+				records = append(records, formatRecord(answer))
+			}
+		}
+	}
+
+	return records, nil
+}
+
+// Check recursion, CHAOS identification, EDNS and DNSSEC support of a DNS server, and try a zone transfer of the domain given
+func inspectDNS(address string, protocol string, port *utils.Port, scan utils.ScanParameters) {
+	info := &utils.DNSInfo{}
+	answered := false
+
+	if query, err := dnsQuery(dnsRecursionName, dnsmessage.TypeA, dnsmessage.ClassINET, true, false, false); err == nil {
+		if response, err := exchangeDNS(address, protocol, query, scan.Timeout); err == nil {
+			answered = true
+			info.Recursion = response.RecursionAvailable && response.RCode == dnsmessage.RCodeSuccess && len(response.Answers) > 0
+		}
+	}
+
+	if !answered {
+		return
+	}
+
+	info.Version = chaosTXT(address, protocol, "version.bind.", scan.Timeout)
+	info.Hostname = chaosTXT(address, protocol, "hostname.bind.", scan.Timeout)
+
+	// Signed zones are only answered with signatures when the DO bit is set, the root zone is signed
+	zone := "."
+	if scan.DNSDomain != "" {
+		zone = strings.TrimSuffix(scan.DNSDomain, ".") + "."
+	}
+
+	if query, err := dnsQuery(zone, dnsTypeDNSKEY, dnsmessage.ClassINET, true, true, true); err == nil {
+		if response, err := exchangeDNS(address, protocol, query, scan.Timeout); err == nil {
+			for _, additional := range response.Additionals {
+				if additional.Header.Type == dnsmessage.TypeOPT {
+					info.EDNS = true
+					info.EDNSPayloadSize = int(additional.Header.Class)
+				}
+			}
+
+			for _, answer := range response.Answers {
+				if answer.Header.Type == dnsTypeRRSIG || answer.Header.Type == dnsTypeDNSKEY {
+					info.DNSSEC = true
+				}
+			}
+		}
+	}
+
+	// Transfers always run over TCP
+	if scan.DNSDomain != "" {
+		info.Domain = scan.DNSDomain
+		if records, err := transferZone(address, zone, scan.Timeout); err == nil {
+			info.ZoneTransfer = true
+			info.ZoneRecords = records
+		}
+	}
+
+	port.DNS = info
+}
+
+// Enumerate a DNS server on a TCP port
+func enumerateDNS(address string, port *utils.Port, scan utils.ScanParameters) {
+	inspectDNS(address, "tcp", port, scan)
+}
+
+// Enumerate a DNS server on a UDP port
+func enumerateDNSUdp(address string, port *utils.Port, scan utils.ScanParameters) {
+	inspectDNS(address, "udp", port, scan)
+}
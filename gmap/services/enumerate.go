@@ -0,0 +1,147 @@
+package services
+
+import (
+	"fmt"
+	"gmap/utils"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Protocol specific inspection of an open port, selected by the service found on it or by its well known ports
+type enumerator struct {
+	// Transport of the ports inspected, TCP when empty
+	protocol string
+	services []string
+	ports    []int
+	run      func(address string, port *utils.Port, scan utils.ScanParameters)
+}
+
+// Enumerators run against open ports, in order
+var enumerators = []enumerator{
+	{services: []string{"http", "https", "http-proxy", "http-alt", "https-alt"}, ports: []int{80, 443, 8000, 8008, 8080, 8443, 8888}, run: enumerateHTTP},
+	{services: []string{"ssh"}, ports: []int{22, 2222}, run: enumerateSSH},
+	{services: []string{"microsoft-ds", "netbios-ssn", "smb"}, ports: []int{139, 445}, run: enumerateSMB},
+	{services: []string{"netbios-ssn"}, ports: []int{139}, run: enumerateNetBIOS},
+	{protocol: "udp", services: []string{"netbios-ns"}, ports: []int{137}, run: enumerateNetBIOS},
+	{services: []string{"domain"}, ports: []int{53}, run: enumerateDNS},
+	{protocol: "udp", services: []string{"domain"}, ports: []int{53}, run: enumerateDNSUdp},
+	{protocol: "udp", services: []string{"snmp"}, ports: []int{161}, run: enumerateSNMP},
+	{services: []string{"mysql"}, ports: []int{3306}, run: enumerateMySQL},
+	{services: []string{"postgresql"}, ports: []int{5432}, run: enumeratePostgres},
+	{services: []string{"ms-sql-s"}, ports: []int{1433}, run: enumerateMSSQL},
+	{services: []string{"mongodb", "mongod"}, ports: []int{27017, 27018, 27019}, run: enumerateMongoDB},
+	{services: []string{"redis"}, ports: []int{6379}, run: enumerateRedis},
+	{services: []string{"memcached", "memcache"}, ports: []int{11211}, run: enumerateMemcached},
+	{services: []string{"elasticsearch"}, ports: []int{9200}, run: enumerateElasticsearch},
+	{services: []string{"kafka"}, ports: []int{9092, 9093}, run: enumerateKafka},
+	{services: []string{"mqtt", "secure-mqtt"}, ports: []int{1883, 8883}, run: enumerateMQTT},
+	{services: []string{"couchdb"}, ports: []int{5984, 6984}, run: enumerateCouchDB},
+	{services: []string{"hadoop"}, ports: []int{50070, 50470, 9870, 9871}, run: enumerateHadoop},
+	{services: []string{"influxdb"}, ports: []int{8086}, run: enumerateInfluxDB},
+	{services: []string{"smtp", "smtps", "submission", "submissions"}, ports: []int{25, 465, 587}, run: enumerateSMTP},
+	{services: []string{"pop3", "pop3s"}, ports: []int{110, 995}, run: enumeratePOP3},
+	{services: []string{"imap", "imaps"}, ports: []int{143, 993}, run: enumerateIMAP},
+	{services: []string{"ms-wbt-server"}, ports: []int{3389}, run: enumerateRDP},
+	{services: []string{"vnc"}, ports: []int{5900, 5901}, run: enumerateVNC},
+}
+
+// Auxiliary function to get the name of a service without the TLS prefix
+func serviceName(service string) string {
+	return strings.TrimPrefix(strings.ToLower(service), "ssl/")
+}
+
+// Check if an enumerator applies to a port of a transport
+func (e enumerator) applies(port utils.Port, protocol string) bool {
+	transport := e.protocol
+	if transport == "" {
+		transport = "tcp"
+	}
+
+	if transport != protocol {
+		return false
+	}
+
+	name := serviceName(port.Service)
+
+	for _, service := range e.services {
+		if name == service {
+			return true
+		}
+	}
+
+	for _, known := range e.ports {
+		if port.Port == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Enumeration worker for go routine multithreading
+func enumerationWorker(scan utils.ScanParameters, port utils.Port, protocol string, results chan<- utils.Port, wg *sync.WaitGroup) {
+	// Ensure worker is done
+	defer wg.Done()
+
+	address := net.JoinHostPort(scan.Target, strconv.Itoa(port.Port))
+
+	for _, enumerator := range enumerators {
+		if enumerator.applies(port, protocol) {
+			enumerator.run(address, &port, scan)
+		}
+	}
+
+	// Products confirmed by an enumeration complete the ones version detection left unknown
+	if port.Database != nil && port.Product == "" {
+		port.Product = port.Database.Product
+		port.Version = port.Database.Version
+	}
+
+	results <- port
+}
+
+// Function to run the protocol enumerations matching the services of the open ports of a transport
+func Enumerate(scan utils.ScanParameters, ports []utils.Port, protocol string) []utils.Port {
+	var results []utils.Port
+	resultChan := make(chan utils.Port, len(ports))
+	var wg sync.WaitGroup
+
+	fmt.Printf("%s[*] Starting service enumeration on host %s%s\n", utils.Blue, scan.Target, utils.Reset)
+	fmt.Println(utils.Lines)
+
+	for _, port := range ports {
+		// UDP services rarely answer empty probes, so they are usually found as open/filtered
+		if port.Status != "open" && (protocol != "udp" || port.Status != "open/filtered") {
+			resultChan <- port
+			continue
+		}
+
+		wg.Add(1)
+		go enumerationWorker(scan, port, protocol, resultChan, &wg)
+	}
+
+	wg.Wait()
+	close(resultChan)
+
+	enumerated := 0
+
+	for result := range resultChan {
+		results = append(results, result)
+
+		if len(result.Details()) == 0 {
+			continue
+		}
+
+		enumerated++
+		utils.PrintSuccess(fmt.Sprintf("[+] %d/%s %s", result.Port, protocol, result.Service))
+		printDetails(result)
+	}
+
+	fmt.Println(utils.Lines)
+	fmt.Printf("%s[*] Service enumeration finished on host %s%s\n", utils.Blue, scan.Target, utils.Reset)
+	fmt.Printf("%s[*] %d ports enumerated %s\n", utils.Blue, enumerated, utils.Reset)
+
+	return results
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"gmap/utils"
+	"html"
+	"io"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// HTTP enumeration parameters
+const (
+	// Bytes of the page read looking for the title and favicon link
+	maxPageSize    = 256 * 1024
+	maxFaviconSize = 1024 * 1024
+)
+
+// Ports expected to serve HTTPS when the scan did not inspect TLS
+var httpsPorts = map[int]bool{443: true, 4443: true, 8443: true}
+
+var (
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	iconPattern  = regexp.MustCompile(`(?is)<link[^>]+rel=["']?(?:shortcut )?icon["']?[^>]*>`)
+	hrefPattern  = regexp.MustCompile(`(?is)href=["']?([^"' >]+)`)
+)
+
+// Auxiliary function to build a client that neither follows redirects nor verifies certificates
+func httpClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout + probeReadTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+		// Redirects are recorded, not followed
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Auxiliary function to issue a GET request and read the start of the body
+func httpGet(client *http.Client, target string, limit int64) (*http.Response, []byte, error) {
+	request, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	request.Header.Set("User-Agent", "Mozilla/5.0 (compatible; gmap)")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, limit))
+	if err != nil && len(body) == 0 {
+		return response, nil, err
+	}
+
+	return response, body, nil
+}
+
+// Auxiliary function to extract the title of a page
+func pageTitle(body []byte) string {
+	match := titlePattern.FindSubmatch(body)
+	if match == nil {
+		return ""
+	}
+
+	return strings.Join(strings.Fields(html.UnescapeString(string(match[1]))), " ")
+}
+
+// Auxiliary function to find the favicon declared by a page, /favicon.ico otherwise
+func faviconURL(base *url.URL, body []byte) string {
+	favicon := &url.URL{Path: "/favicon.ico"}
+
+	if link := iconPattern.Find(body); link != nil {
+		if href := hrefPattern.FindSubmatch(link); href != nil {
+			if parsed, err := url.Parse(html.UnescapeString(string(href[1]))); err == nil {
+				favicon = parsed
+			}
+		}
+	}
+
+	return base.ResolveReference(favicon).String()
+}
+
+// Auxiliary function to compute the 32 bit MurmurHash3 of some data
+func murmur3(data []byte) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	var hash uint32
+
+	blocks := len(data) / 4
+	for i := 0; i < blocks; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k = bits.RotateLeft32(k*c1, 15) * c2
+		hash = bits.RotateLeft32(hash^k, 13)*5 + 0xe6546b64
+	}
+
+	var k uint32
+	tail := data[blocks*4:]
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		hash ^= bits.RotateLeft32(k*c1, 15) * c2
+	}
+
+	hash ^= uint32(len(data))
+	hash ^= hash >> 16
+	hash *= 0x85ebca6b
+	hash ^= hash >> 13
+	hash *= 0xc2b2ae35
+	hash ^= hash >> 16
+
+	return hash
+}
+
+// Hash a favicon the way Shodan does, MurmurHash3 of its base64 encoding wrapped every 76 characters
+func faviconHash(favicon []byte) int32 {
+	encoded := base64.StdEncoding.EncodeToString(favicon)
+
+	var wrapped strings.Builder
+	for len(encoded) > 76 {
+		wrapped.WriteString(encoded[:76] + "\n")
+		encoded = encoded[76:]
+	}
+	wrapped.WriteString(encoded + "\n")
+
+	return int32(murmur3([]byte(wrapped.String())))
+}
+
+// Auxiliary function to check if a port should be spoken to over HTTPS
+func speaksHTTPS(port *utils.Port) bool {
+	return port.TLS != nil || strings.HasPrefix(port.Service, "ssl/") || serviceName(port.Service) == "https" || httpsPorts[port.Port]
+}
+
+// Request the root page of a web server and record its status, server, title, redirect and favicon hash
+func enumerateHTTP(address string, port *utils.Port, scan utils.ScanParameters) {
+	scheme := "http"
+	if speaksHTTPS(port) {
+		scheme = "https"
+	}
+
+	base := &url.URL{Scheme: scheme, Host: address, Path: "/"}
+	client := httpClient(scan.Timeout)
+
+	response, body, err := httpGet(client, base.String(), maxPageSize)
+	if err != nil {
+		return
+	}
+
+	info := &utils.HTTPInfo{
+		StatusCode: response.StatusCode,
+		Server:     response.Header.Get("Server"),
+		Title:      pageTitle(body),
+		Location:   response.Header.Get("Location"),
+	}
+
+	if favicon, data, err := httpGet(client, faviconURL(base, body), maxFaviconSize); err == nil && favicon.StatusCode == http.StatusOK && len(data) > 0 {
+		info.FaviconHash = faviconHash(data)
+	}
+
+	port.HTTP = info
+}
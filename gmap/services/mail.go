@@ -0,0 +1,402 @@
+package services
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"gmap/utils"
+	"net"
+	"strings"
+	"time"
+)
+
+// Lines read at most from a multiline reply
+const maxMailLines = 100
+
+// Cleartext session with a mail server, upgraded in place by STARTTLS
+type mailSession struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+// Commands and replies of a mail protocol needed to read its capabilities and start TLS
+type mailProtocol struct {
+	name string
+	// Read the greeting sent on connection
+	greeting func(session *mailSession) (string, error)
+	// List the capabilities of the server, they change once TLS is started
+	capabilities func(session *mailSession) ([]string, error)
+	// Capability announcing STARTTLS and the command starting it
+	startTLS        string
+	startTLSCommand func(session *mailSession) error
+	// Authentication mechanisms offered by a capability list
+	mechanisms func(capabilities []string) []string
+	// Whether a capability list lets clients send passwords in cleartext
+	plaintext func(capabilities []string) bool
+}
+
+// SASL mechanisms sending the password as is
+var plaintextMechanisms = map[string]bool{"PLAIN": true, "LOGIN": true}
+
+// Auxiliary function to read a line without its line ending
+func (s *mailSession) readLine() (string, error) {
+	s.conn.SetDeadline(time.Now().Add(s.timeout + probeReadTimeout))
+
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Auxiliary function to send a command
+func (s *mailSession) send(command string) error {
+	s.conn.SetDeadline(time.Now().Add(s.timeout + probeReadTimeout))
+
+	_, err := s.conn.Write([]byte(command + "\r\n"))
+	return err
+}
+
+// Auxiliary function to complete a TLS handshake over the session
+func (s *mailSession) upgrade() (*utils.TLSInfo, error) {
+	tlsConn := tls.Client(s.conn, &tls.Config{InsecureSkipVerify: true, CipherSuites: allCipherSuites()})
+	tlsConn.SetDeadline(time.Now().Add(s.timeout + probeReadTimeout))
+
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+
+	s.conn = tlsConn
+	s.reader = bufio.NewReader(tlsConn)
+
+	return describeTLS(tlsConn.ConnectionState()), nil
+}
+
+// Auxiliary function to read an SMTP reply, the code ends every line and is followed by a dash on all but the last
+func readSMTPReply(session *mailSession) (string, []string, error) {
+	var lines []string
+
+	for len(lines) < maxMailLines {
+		line, err := session.readLine()
+		if err != nil {
+			return "", nil, err
+		}
+
+		if len(line) < 3 {
+			return "", nil, errors.New("invalid reply")
+		}
+		lines = append(lines, strings.TrimSpace(strings.TrimPrefix(line[3:], "-")))
+
+		if len(line) == 3 || line[3] != '-' {
+			return line[:3], lines, nil
+		}
+	}
+
+	return "", nil, errors.New("reply too long")
+}
+
+// Auxiliary function to read a POP3 status line, positive when it starts with +OK
+func readPOP3Status(session *mailSession) (string, error) {
+	line, err := session.readLine()
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(line, "+OK") {
+		return "", errors.New(line)
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(line, "+OK")), nil
+}
+
+// Auxiliary function to send a tagged IMAP command, returning the untagged lines and the completion result
+func imapCommand(session *mailSession, tag string, command string) ([]string, string, error) {
+	if err := session.send(tag + " " + command); err != nil {
+		return nil, "", err
+	}
+
+	var untagged []string
+	for len(untagged) < maxMailLines {
+		line, err := session.readLine()
+		if err != nil {
+			return nil, "", err
+		}
+
+		if result, found := strings.CutPrefix(line, tag+" "); found {
+			return untagged, result, nil
+		}
+		untagged = append(untagged, line)
+	}
+
+	return nil, "", errors.New("reply too long")
+}
+
+// Auxiliary function to keep the capabilities starting with a prefix, without it
+func capabilitiesWithPrefix(capabilities []string, prefix string) []string {
+	var values []string
+
+	for _, capability := range capabilities {
+		if value, found := strings.CutPrefix(strings.ToUpper(capability), prefix); found {
+			values = append(values, strings.Fields(value)...)
+		}
+	}
+
+	return values
+}
+
+// Auxiliary function to check if any of the mechanisms sends passwords in cleartext
+func offersPlaintext(mechanisms []string) bool {
+	for _, mechanism := range mechanisms {
+		if plaintextMechanisms[mechanism] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Auxiliary function to get the mechanisms of the SMTP AUTH extension, some old servers write AUTH=PLAIN LOGIN
+func smtpMechanisms(capabilities []string) []string {
+	return append(capabilitiesWithPrefix(capabilities, "AUTH "), capabilitiesWithPrefix(capabilities, "AUTH=")...)
+}
+
+// SMTP, where EHLO lists the extensions of the server
+var smtpProtocol = mailProtocol{
+	name: "SMTP",
+	greeting: func(session *mailSession) (string, error) {
+		code, lines, err := readSMTPReply(session)
+		if err != nil {
+			return "", err
+		}
+
+		if code != "220" {
+			return "", errors.New(strings.Join(lines, " "))
+		}
+
+		return strings.Join(lines, " "), nil
+	},
+	capabilities: func(session *mailSession) ([]string, error) {
+		if err := session.send("EHLO gmap"); err != nil {
+			return nil, err
+		}
+
+		code, lines, err := readSMTPReply(session)
+		if err != nil {
+			return nil, err
+		}
+
+		// Servers without extensions only know HELO
+		if code != "250" {
+			return nil, nil
+		}
+
+		// The first line greets the client
+		return lines[1:], nil
+	},
+	startTLS: "STARTTLS",
+	startTLSCommand: func(session *mailSession) error {
+		if err := session.send("STARTTLS"); err != nil {
+			return err
+		}
+
+		code, lines, err := readSMTPReply(session)
+		if err != nil {
+			return err
+		}
+
+		if code != "220" {
+			return errors.New(strings.Join(lines, " "))
+		}
+
+		return nil
+	},
+	mechanisms: smtpMechanisms,
+	plaintext: func(capabilities []string) bool {
+		return offersPlaintext(smtpMechanisms(capabilities))
+	},
+}
+
+// POP3, where CAPA lists the extensions and SASL the mechanisms
+var pop3Protocol = mailProtocol{
+	name:     "POP3",
+	greeting: readPOP3Status,
+	capabilities: func(session *mailSession) ([]string, error) {
+		if err := session.send("CAPA"); err != nil {
+			return nil, err
+		}
+
+		// Servers without extensions only know USER and PASS
+		if _, err := readPOP3Status(session); err != nil {
+			return []string{"USER"}, nil
+		}
+
+		var capabilities []string
+		for len(capabilities) < maxMailLines {
+			line, err := session.readLine()
+			if err != nil {
+				return nil, err
+			}
+
+			if line == "." {
+				break
+			}
+			capabilities = append(capabilities, line)
+		}
+
+		return capabilities, nil
+	},
+	startTLS: "STLS",
+	startTLSCommand: func(session *mailSession) error {
+		if err := session.send("STLS"); err != nil {
+			return err
+		}
+
+		_, err := readPOP3Status(session)
+		return err
+	},
+	mechanisms: func(capabilities []string) []string {
+		return capabilitiesWithPrefix(capabilities, "SASL ")
+	},
+	plaintext: func(capabilities []string) bool {
+		return hasCapability(capabilities, "USER") || offersPlaintext(capabilitiesWithPrefix(capabilities, "SASL "))
+	},
+}
+
+// IMAP, where CAPABILITY lists the extensions, AUTH= the mechanisms and LOGINDISABLED forbids the LOGIN command
+var imapProtocol = mailProtocol{
+	name: "IMAP",
+	greeting: func(session *mailSession) (string, error) {
+		line, err := session.readLine()
+		if err != nil {
+			return "", err
+		}
+
+		if !strings.HasPrefix(line, "* OK") && !strings.HasPrefix(line, "* PREAUTH") {
+			return "", errors.New(line)
+		}
+
+		return strings.TrimSpace(strings.TrimPrefix(line, "*")), nil
+	},
+	capabilities: func(session *mailSession) ([]string, error) {
+		untagged, result, err := imapCommand(session, "a1", "CAPABILITY")
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(result, "OK") {
+			return nil, errors.New(result)
+		}
+
+		for _, line := range untagged {
+			if list, found := strings.CutPrefix(line, "* CAPABILITY "); found {
+				return strings.Fields(list), nil
+			}
+		}
+
+		return nil, nil
+	},
+	startTLS: "STARTTLS",
+	startTLSCommand: func(session *mailSession) error {
+		_, result, err := imapCommand(session, "a2", "STARTTLS")
+		if err != nil {
+			return err
+		}
+
+		if !strings.HasPrefix(result, "OK") {
+			return errors.New(result)
+		}
+
+		return nil
+	},
+	mechanisms: func(capabilities []string) []string {
+		return capabilitiesWithPrefix(capabilities, "AUTH=")
+	},
+	plaintext: func(capabilities []string) bool {
+		return !hasCapability(capabilities, "LOGINDISABLED") || offersPlaintext(capabilitiesWithPrefix(capabilities, "AUTH="))
+	},
+}
+
+// Auxiliary function to check if a capability list contains a capability
+func hasCapability(capabilities []string, name string) bool {
+	for _, capability := range capabilities {
+		if strings.EqualFold(capability, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Read the greeting and capabilities of a mail server, start TLS when offered to inspect the session and list the authentication mechanisms
+func inspectMail(address string, port *utils.Port, scan utils.ScanParameters, protocol mailProtocol) {
+	implicitTLS := speaksTLS(address, port, scan.Timeout)
+
+	conn, err := dialDatabase(address, implicitTLS, scan.Timeout)
+	if err != nil {
+		return
+	}
+	defer func() { conn.Close() }()
+
+	session := &mailSession{conn: conn, reader: bufio.NewReader(conn), timeout: scan.Timeout}
+
+	greeting, err := protocol.greeting(session)
+	if err != nil {
+		return
+	}
+
+	capabilities, err := protocol.capabilities(session)
+	if err != nil {
+		return
+	}
+
+	info := &utils.MailInfo{
+		Protocol:       protocol.name,
+		Greeting:       greeting,
+		Capabilities:   capabilities,
+		ImplicitTLS:    implicitTLS,
+		AuthMechanisms: protocol.mechanisms(capabilities),
+	}
+	port.Mail = info
+
+	// Ports encrypted from the start are inspected like any other TLS port
+	if implicitTLS {
+		if port.TLS == nil {
+			port.TLS = describeTLS(conn.(*tls.Conn).ConnectionState())
+		}
+		return
+	}
+
+	info.PlaintextAuth = protocol.plaintext(capabilities)
+	info.StartTLS = hasCapability(capabilities, protocol.startTLS)
+
+	if !info.StartTLS || protocol.startTLSCommand(session) != nil {
+		return
+	}
+
+	if info.StartTLSSession, err = session.upgrade(); err != nil {
+		return
+	}
+	conn = session.conn
+
+	// Mechanisms are often only offered once the session is encrypted
+	if capabilities, err := protocol.capabilities(session); err == nil {
+		info.AuthMechanisms = protocol.mechanisms(capabilities)
+	}
+}
+
+// Enumerate an SMTP server
+func enumerateSMTP(address string, port *utils.Port, scan utils.ScanParameters) {
+	inspectMail(address, port, scan, smtpProtocol)
+}
+
+// Enumerate a POP3 server
+func enumeratePOP3(address string, port *utils.Port, scan utils.ScanParameters) {
+	inspectMail(address, port, scan, pop3Protocol)
+}
+
+// Enumerate an IMAP server
+func enumerateIMAP(address string, port *utils.Port, scan utils.ScanParameters) {
+	inspectMail(address, port, scan, imapProtocol)
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"gmap/utils"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+)
+
+// MongoDB wire protocol parameters
+const (
+	mongoOpReply = 1
+	mongoOpQuery = 2004
+	mongoOpMsg   = 2013
+	// Error code of commands needing authentication
+	mongoUnauthorized = 13
+)
+
+// BSON element types
+const (
+	bsonDouble   = 0x01
+	bsonString   = 0x02
+	bsonDocument = 0x03
+	bsonArray    = 0x04
+	bsonBinary   = 0x05
+	bsonObjectId = 0x07
+	bsonBool     = 0x08
+	bsonDateTime = 0x09
+	bsonNull     = 0x0a
+	bsonInt32    = 0x10
+	bsonStamp    = 0x11
+	bsonInt64    = 0x12
+	bsonDecimal  = 0x13
+)
+
+// Auxiliary function to encode a command, a document of int32 and string fields in order
+func bsonCommand(fields ...interface{}) []byte {
+	var elements []byte
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		name := fields[i].(string) + "\x00"
+
+		switch value := fields[i+1].(type) {
+		case int:
+			elements = append(append(append(elements, bsonInt32), name...), binary.LittleEndian.AppendUint32(nil, uint32(value))...)
+		case string:
+			elements = append(append(append(elements, bsonString), name...), binary.LittleEndian.AppendUint32(nil, uint32(len(value)+1))...)
+			elements = append(elements, value+"\x00"...)
+		}
+	}
+
+	document := binary.LittleEndian.AppendUint32(nil, uint32(len(elements)+5))
+	return append(append(document, elements...), 0)
+}
+
+// Decode the top level fields of a document, numbers as float64, nested documents and binaries are skipped
+func bsonDecode(document []byte) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+
+	if len(document) < 5 {
+		return nil, errors.New("truncated document")
+	}
+	data := document[4 : len(document)-1]
+
+	for len(data) > 0 {
+		kind := data[0]
+		name, rest, found := bytes.Cut(data[1:], []byte{0})
+		if !found {
+			return nil, errors.New("truncated field name")
+		}
+
+		size := 0
+		switch kind {
+		case bsonDouble, bsonDateTime, bsonStamp, bsonInt64:
+			size = 8
+		case bsonInt32:
+			size = 4
+		case bsonBool:
+			size = 1
+		case bsonObjectId:
+			size = 12
+		case bsonDecimal:
+			size = 16
+		case bsonNull:
+			size = 0
+		case bsonString:
+			if len(rest) < 4 {
+				return nil, errors.New("truncated string")
+			}
+			size = 4 + int(binary.LittleEndian.Uint32(rest))
+		case bsonDocument, bsonArray:
+			if len(rest) < 4 {
+				return nil, errors.New("truncated document")
+			}
+			size = int(binary.LittleEndian.Uint32(rest))
+		case bsonBinary:
+			if len(rest) < 4 {
+				return nil, errors.New("truncated binary")
+			}
+			size = 5 + int(binary.LittleEndian.Uint32(rest))
+		default:
+			return fields, nil
+		}
+
+		if size < 0 || size > len(rest) {
+			return nil, errors.New("truncated field")
+		}
+		value := rest[:size]
+
+		switch kind {
+		case bsonDouble:
+			fields[string(name)] = math.Float64frombits(binary.LittleEndian.Uint64(value))
+		case bsonInt32:
+			fields[string(name)] = float64(int32(binary.LittleEndian.Uint32(value)))
+		case bsonInt64:
+			fields[string(name)] = float64(int64(binary.LittleEndian.Uint64(value)))
+		case bsonBool:
+			fields[string(name)] = value[0] != 0
+		case bsonString:
+			fields[string(name)] = string(bytes.TrimRight(value[4:], "\x00"))
+		}
+
+		data = rest[size:]
+	}
+
+	return fields, nil
+}
+
+// Auxiliary function to send a wire protocol message and read the body of the reply
+func mongoRequest(conn net.Conn, opcode uint32, replyOpcode uint32, body []byte) ([]byte, error) {
+	header := binary.LittleEndian.AppendUint32(nil, uint32(16+len(body)))
+	header = binary.LittleEndian.AppendUint32(header, uint32(rand.Int31()))
+	header = binary.LittleEndian.AppendUint32(header, 0)
+	header = binary.LittleEndian.AppendUint32(header, opcode)
+
+	if _, err := conn.Write(append(header, body...)); err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, 16)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(reply)
+	if length < 16+5+5 || length > maxResponseSize*4 || binary.LittleEndian.Uint32(reply[12:]) != replyOpcode {
+		return nil, errors.New("invalid reply")
+	}
+
+	message := make([]byte, length-16)
+	if _, err := io.ReadFull(conn, message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// Run a command with OP_MSG and decode the reply
+func mongoCommand(conn net.Conn, command []byte) (map[string]interface{}, error) {
+	// Flags and a single body section
+	message, err := mongoRequest(conn, mongoOpMsg, mongoOpMsg, append([]byte{0, 0, 0, 0, 0}, command...))
+	if err != nil {
+		return nil, err
+	}
+
+	return bsonDecode(message[5:])
+}
+
+// Run a command with an OP_QUERY on admin.$cmd, the only way to talk to servers older than 3.6, and decode the reply
+func mongoQuery(conn net.Conn, command []byte) (map[string]interface{}, error) {
+	// Flags, collection, documents to skip and a single document to return
+	body := append([]byte{0, 0, 0, 0}, "admin.$cmd\x00"...)
+	body = binary.LittleEndian.AppendUint32(body, 0)
+	body = binary.LittleEndian.AppendUint32(body, math.MaxUint32)
+
+	message, err := mongoRequest(conn, mongoOpQuery, mongoOpReply, append(body, command...))
+	if err != nil {
+		return nil, err
+	}
+
+	// Response flags, cursor ID, starting position and number of documents precede the reply
+	if len(message) < 20+5 || binary.LittleEndian.Uint32(message[16:]) == 0 {
+		return nil, errors.New("empty reply")
+	}
+
+	return bsonDecode(message[20:])
+}
+
+// Ask MongoDB for its build information, then list the databases to learn whether authentication is enabled
+func enumerateMongoDB(address string, port *utils.Port, scan utils.ScanParameters) {
+	useTLS := speaksTLS(address, port, scan.Timeout)
+
+	conn, err := dialDatabase(address, useTLS, scan.Timeout)
+	if err != nil {
+		return
+	}
+	defer func() { conn.Close() }()
+
+	// OP_MSG needs MongoDB 3.6, older servers drop the connection and are asked again with OP_QUERY
+	run := func(name string) (map[string]interface{}, error) {
+		return mongoCommand(conn, bsonCommand(name, 1, "$db", "admin"))
+	}
+
+	// isMaster is answered by every version, hello only since 4.4
+	hello, err := run("isMaster")
+	if err != nil {
+		conn.Close()
+		if conn, err = dialDatabase(address, useTLS, scan.Timeout); err != nil {
+			return
+		}
+
+		run = func(name string) (map[string]interface{}, error) {
+			return mongoQuery(conn, bsonCommand(name, 1))
+		}
+
+		if hello, err = run("isMaster"); err != nil {
+			return
+		}
+	}
+
+	info := &utils.DatabaseInfo{Product: "MongoDB", TLS: useTLS}
+
+	if setName, ok := hello["setName"].(string); ok {
+		info.ClusterName = setName
+	}
+
+	// Build information is public on most versions
+	if build, err := run("buildInfo"); err == nil {
+		if version, ok := build["version"].(string); ok {
+			info.Version = version
+		}
+	}
+
+	if databases, err := run("listDatabases"); err == nil {
+		if ok, _ := databases["ok"].(float64); ok != 1 {
+			info.AuthRequired = true
+			if code, _ := databases["code"].(float64); code != mongoUnauthorized {
+				info.Info, _ = databases["errmsg"].(string)
+			}
+		}
+	}
+
+	port.Database = info
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gmap/utils"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NetBIOS name service parameters
+const (
+	netbiosNamePort = 137
+	// Node status request type
+	netbiosStatusType = 0x21
+	// Flag of group names in the name table
+	netbiosGroupFlag = 0x8000
+)
+
+// Auxiliary function to first-level encode a NetBIOS name, padded to 15 characters and followed by its suffix
+func encodeNetBIOSName(name string, padding byte, suffix byte) []byte {
+	raw := bytes.Repeat([]byte{padding}, 16)
+	copy(raw, strings.ToUpper(name))
+	raw[15] = suffix
+
+	encoded := []byte{32}
+	for _, b := range raw {
+		encoded = append(encoded, 'A'+b>>4, 'A'+b&0x0f)
+	}
+
+	return append(encoded, 0)
+}
+
+// Auxiliary function to skip an encoded name, either as labels or as a compression pointer
+func skipNetBIOSName(data []byte, offset int) (int, error) {
+	for offset < len(data) {
+		length := int(data[offset])
+
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xc0 == 0xc0:
+			return offset + 2, nil
+		}
+
+		offset += length + 1
+	}
+
+	return 0, errors.New("truncated name")
+}
+
+// Parse the name table and MAC address of a node status response
+func parseNodeStatus(response []byte) (*utils.NetBIOSInfo, error) {
+	if len(response) < 12 || binary.BigEndian.Uint16(response[6:]) == 0 {
+		return nil, errors.New("no answer")
+	}
+
+	offset, err := skipNetBIOSName(response, 12)
+	if err != nil {
+		return nil, err
+	}
+
+	// Type, class, TTL and data length precede the number of names
+	offset += 10
+	if offset >= len(response) {
+		return nil, errors.New("truncated answer")
+	}
+
+	count := int(response[offset])
+	offset++
+
+	info := &utils.NetBIOSInfo{}
+
+	for i := 0; i < count; i++ {
+		if offset+18 > len(response) {
+			return nil, errors.New("truncated name table")
+		}
+
+		entry := response[offset : offset+18]
+		info.Names = append(info.Names, utils.NetBIOSEntry{
+			Name:   strings.TrimRight(string(entry[:15]), " \x00"),
+			Suffix: fmt.Sprintf("%02X", entry[15]),
+			Group:  binary.BigEndian.Uint16(entry[16:])&netbiosGroupFlag != 0,
+		})
+		offset += 18
+	}
+
+	// The statistics start with the unit ID, the MAC address of the adapter
+	if offset+6 <= len(response) {
+		if mac := net.HardwareAddr(response[offset : offset+6]); !bytes.Equal(mac, make([]byte, 6)) {
+			info.MAC = mac.String()
+		}
+	}
+
+	return info, nil
+}
+
+// Send a node status request to the NetBIOS name service of a host and return its name table
+func queryNodeStatus(target string, timeout time.Duration) (*utils.NetBIOSInfo, error) {
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(target, strconv.Itoa(netbiosNamePort)), timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	request := binary.BigEndian.AppendUint16(nil, uint16(rand.Intn(0x10000)))
+	// No flags, one question
+	request = append(request, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0)
+	request = append(request, encodeNetBIOSName("*", 0, 0)...)
+	request = append(request, 0, netbiosStatusType, 0, 1)
+
+	conn.SetDeadline(time.Now().Add(timeout + probeReadTimeout))
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, 2048)
+	n, err := conn.Read(response)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseNodeStatus(response[:n])
+}
+
+// Query the NetBIOS name table and MAC address of the host of a port
+func enumerateNetBIOS(address string, port *utils.Port, scan utils.ScanParameters) {
+	if info, err := queryNodeStatus(scan.Target, scan.Timeout); err == nil {
+		port.NetBIOS = info
+	}
+}
@@ -0,0 +1,473 @@
+package services
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default probe database in nmap-service-probes format, a full nmap database can be loaded instead
+//
+//go:embed nmap-service-probes
+var serviceProbesTable string
+
+// Probes are tried when their rarity is at most the intensity, 7 as in nmap by default
+const DefaultVersionIntensity = 7
+
+// Payload sent to a service and the signatures of its responses
+type serviceProbe struct {
+	protocol string
+	name     string
+	payload  []byte
+	// How rarely the probe gets an answer, from 1 to 9
+	rarity int
+	// Ports where the service is commonly found, in clear text and inside TLS
+	ports    map[int]bool
+	sslPorts map[int]bool
+	// Time to wait for a response, zero for the default
+	wait time.Duration
+	// Probes whose signatures are also tried on the responses to this one
+	fallbackNames []string
+	fallbacks     []*serviceProbe
+	matches       []serviceMatch
+}
+
+// Signature of a response, the version fields are templates filled with the groups of the pattern
+type serviceMatch struct {
+	service string
+	pattern *regexp.Regexp
+	// Soft matches only identify the service, probing goes on to find the version
+	soft    bool
+	product string
+	version string
+	info    string
+}
+
+// Probes and ports excluded from version detection
+type probeDatabase struct {
+	probes   []*serviceProbe
+	excluded map[int]bool
+}
+
+var (
+	serviceDatabase     *probeDatabase
+	serviceDatabaseErr  error
+	serviceDatabaseOnce sync.Once
+	// Database file given by the user, the bundled one is used when empty
+	serviceProbesFile string
+)
+
+// Use an nmap-service-probes file instead of the bundled database
+func SetServiceProbesFile(file string) {
+	serviceProbesFile = file
+}
+
+// Auxiliary function to decode the escapes of a probe payload
+func unescapePayload(payload string) []byte {
+	var decoded []byte
+
+	for i := 0; i < len(payload); i++ {
+		if payload[i] != '\\' || i+1 == len(payload) {
+			decoded = append(decoded, payload[i])
+			continue
+		}
+
+		i++
+		switch payload[i] {
+		case '0':
+			decoded = append(decoded, 0)
+		case 'r':
+			decoded = append(decoded, '\r')
+		case 'n':
+			decoded = append(decoded, '\n')
+		case 't':
+			decoded = append(decoded, '\t')
+		case 'x':
+			if i+2 < len(payload) {
+				if value, err := strconv.ParseUint(payload[i+1:i+3], 16, 8); err == nil {
+					decoded = append(decoded, byte(value))
+					i += 2
+					continue
+				}
+			}
+			decoded = append(decoded, 'x')
+		default:
+			decoded = append(decoded, payload[i])
+		}
+	}
+
+	return decoded
+}
+
+// Auxiliary function to read a field delimited by the character following its prefix, e.g. q|...|
+func cutDelimited(text string) (string, string, bool) {
+	if len(text) < 2 {
+		return "", "", false
+	}
+
+	delimiter := text[0]
+	end := strings.IndexByte(text[1:], delimiter)
+	if end < 0 {
+		return "", "", false
+	}
+
+	return text[1 : end+1], text[end+2:], true
+}
+
+// Auxiliary function to translate an nmap style pattern to Go syntax
+func compilePattern(pattern string, flags string) (*regexp.Regexp, error) {
+	var translated strings.Builder
+
+	for i := 0; i < len(pattern); i++ {
+		// Go does not understand \0, the escape for a null byte
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			if pattern[i+1] == '0' {
+				translated.WriteString(`\x00`)
+			} else {
+				translated.WriteString(pattern[i : i+2])
+			}
+			i++
+			continue
+		}
+
+		translated.WriteByte(pattern[i])
+	}
+
+	prefix := ""
+	if strings.Contains(flags, "s") {
+		prefix += "s"
+	}
+	if strings.Contains(flags, "i") {
+		prefix += "i"
+	}
+	if prefix != "" {
+		prefix = "(?" + prefix + ")"
+	}
+
+	return regexp.Compile(prefix + translated.String())
+}
+
+// Parse a match line: <service> m|<regex>|[flags] [p/product/] [v/version/] [i/info/] [h//] [o//] [d//] [cpe:/.../]
+func parseMatch(line string, soft bool) (serviceMatch, error) {
+	match := serviceMatch{soft: soft}
+
+	service, rest, ok := strings.Cut(line, " ")
+	if !ok || !strings.HasPrefix(rest, "m") {
+		return match, fmt.Errorf("missing pattern")
+	}
+	match.service = service
+
+	pattern, rest, ok := cutDelimited(rest[1:])
+	if !ok {
+		return match, fmt.Errorf("unterminated pattern")
+	}
+
+	flags, rest, _ := strings.Cut(rest, " ")
+
+	compiled, err := compilePattern(pattern, flags)
+	if err != nil {
+		return match, err
+	}
+	match.pattern = compiled
+
+	// Version fields, each one a name followed by a delimited template
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		field := rest[:1]
+		if strings.HasPrefix(rest, "cpe:") {
+			field = "cpe:"
+		}
+
+		var value string
+		if value, rest, ok = cutDelimited(rest[len(field):]); !ok {
+			return match, fmt.Errorf("unterminated field %s", field)
+		}
+
+		// Drop the flags following a field, e.g. the a of cpe:/a:apache:http_server/a
+		if end := strings.IndexByte(rest, ' '); end > 0 {
+			rest = rest[end:]
+		} else if end < 0 {
+			rest = ""
+		}
+
+		switch field {
+		case "p":
+			match.product = value
+		case "v":
+			match.version = value
+		case "i":
+			match.info = value
+		}
+	}
+
+	return match, nil
+}
+
+// Auxiliary function to parse a port list such as 21,80-85,T:443
+func parsePortList(list string) map[int]bool {
+	ports := make(map[int]bool)
+
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+
+		// Exclude directives may prefix ports with their protocol, only TCP ports are probed
+		if protocol, rest, ok := strings.Cut(item, ":"); ok {
+			if protocol != "T" {
+				continue
+			}
+			item = rest
+		}
+
+		first, last, isRange := strings.Cut(item, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			continue
+		}
+
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil {
+				continue
+			}
+		}
+
+		for port := start; port <= end && port <= 65535; port++ {
+			ports[port] = true
+		}
+	}
+
+	return ports
+}
+
+// Parse a database in nmap-service-probes format
+func parseServiceProbes(reader io.Reader) (*probeDatabase, error) {
+	database := &probeDatabase{excluded: make(map[int]bool)}
+
+	lines := bufio.NewScanner(reader)
+	lines.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		directive, rest, _ := strings.Cut(line, " ")
+		rest = strings.TrimSpace(rest)
+
+		if directive == "Exclude" {
+			for port := range parsePortList(rest) {
+				database.excluded[port] = true
+			}
+			continue
+		}
+
+		if directive == "Probe" {
+			fields := strings.SplitN(rest, " ", 3)
+			if len(fields) < 3 || !strings.HasPrefix(fields[2], "q") {
+				return nil, fmt.Errorf("invalid probe: %s", line)
+			}
+
+			payload, _, ok := cutDelimited(fields[2][1:])
+			if !ok {
+				return nil, fmt.Errorf("unterminated probe payload: %s", line)
+			}
+
+			database.probes = append(database.probes, &serviceProbe{
+				protocol: fields[0],
+				name:     fields[1],
+				payload:  unescapePayload(payload),
+				rarity:   1,
+				ports:    make(map[int]bool),
+				sslPorts: make(map[int]bool),
+			})
+			continue
+		}
+
+		// Every other directive belongs to the last probe
+		if len(database.probes) == 0 {
+			continue
+		}
+		probe := database.probes[len(database.probes)-1]
+
+		switch directive {
+		case "match", "softmatch":
+			// Signatures Go cannot compile (e.g. backreferences or lookaheads) are skipped
+			if match, err := parseMatch(rest, directive == "softmatch"); err == nil {
+				probe.matches = append(probe.matches, match)
+			}
+		case "ports":
+			probe.ports = parsePortList(rest)
+		case "sslports":
+			probe.sslPorts = parsePortList(rest)
+		case "rarity":
+			if rarity, err := strconv.Atoi(rest); err == nil {
+				probe.rarity = rarity
+			}
+		case "totalwaitms":
+			if wait, err := strconv.Atoi(rest); err == nil {
+				probe.wait = time.Duration(wait) * time.Millisecond
+			}
+		case "fallback":
+			probe.fallbackNames = strings.Split(rest, ",")
+		}
+	}
+
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+
+	// Resolve fallbacks once every probe is known
+	byName := make(map[string]*serviceProbe)
+	for _, probe := range database.probes {
+		byName[probe.protocol+"/"+probe.name] = probe
+	}
+
+	for _, probe := range database.probes {
+		for _, name := range probe.fallbackNames {
+			if fallback, ok := byName[probe.protocol+"/"+strings.TrimSpace(name)]; ok {
+				probe.fallbacks = append(probe.fallbacks, fallback)
+			}
+		}
+	}
+
+	return database, nil
+}
+
+// Auxiliary function to load the probe database once
+func loadServiceProbes() (*probeDatabase, error) {
+	serviceDatabaseOnce.Do(func() {
+		if serviceProbesFile == "" {
+			serviceDatabase, serviceDatabaseErr = parseServiceProbes(strings.NewReader(serviceProbesTable))
+			return
+		}
+
+		file, err := os.Open(serviceProbesFile)
+		if err != nil {
+			serviceDatabaseErr = err
+			return
+		}
+		defer file.Close()
+
+		serviceDatabase, serviceDatabaseErr = parseServiceProbes(file)
+	})
+
+	return serviceDatabase, serviceDatabaseErr
+}
+
+// Probes to send to a port in order: NULL first, then the probes registered for the port, then the rest by rarity
+func (d *probeDatabase) probesFor(port int, intensity int, wrapTLS bool) []*serviceProbe {
+	var registered, others []*serviceProbe
+
+	for _, probe := range d.probes {
+		if probe.protocol != "TCP" {
+			continue
+		}
+
+		// There is no banner to wait for inside TLS, the server waits for the client
+		if len(probe.payload) == 0 {
+			if !wrapTLS {
+				registered = append([]*serviceProbe{probe}, registered...)
+			}
+			continue
+		}
+
+		ports := probe.ports
+		if wrapTLS {
+			ports = probe.sslPorts
+		}
+
+		// Probes registered for the port are tried whatever the intensity
+		if ports[port] {
+			registered = append(registered, probe)
+		} else if probe.rarity <= intensity {
+			others = append(others, probe)
+		}
+	}
+
+	return append(registered, others...)
+}
+
+// Auxiliary function to check whether a probe may identify a service
+func (p *serviceProbe) canMatch(service string) bool {
+	for _, probe := range append([]*serviceProbe{p}, p.fallbacks...) {
+		for _, match := range probe.matches {
+			if match.service == service {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Auxiliary function to decode a response so every byte is a single character, as patterns expect
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+
+	return string(runes)
+}
+
+// Helpers available in version templates
+var templateHelper = regexp.MustCompile(`\$(P|SUBST|I)\((\d)(?:,"([^"]*)")?(?:,"([^"]*)")?\)`)
+
+// Auxiliary function to fill a template with the groups of a match
+func fillTemplate(template string, groups []string) string {
+	template = templateHelper.ReplaceAllStringFunc(template, func(helper string) string {
+		parts := templateHelper.FindStringSubmatch(helper)
+		index, _ := strconv.Atoi(parts[2])
+		if index >= len(groups) {
+			return ""
+		}
+		group := groups[index]
+
+		switch parts[1] {
+		// Printable characters only
+		case "P":
+			return strings.Map(func(r rune) rune {
+				if r < 0x20 || r > 0x7e {
+					return -1
+				}
+				return r
+			}, group)
+		// Replace a string
+		case "SUBST":
+			return strings.ReplaceAll(group, parts[3], parts[4])
+		// Unpack an unsigned integer, > for big endian and < for little endian
+		case "I":
+			var data []byte
+			for _, r := range group {
+				data = append(data, byte(r))
+			}
+			if len(data) == 0 || len(data) > 8 {
+				return ""
+			}
+
+			padded := make([]byte, 8)
+			if parts[3] == "<" {
+				copy(padded, data)
+				return strconv.FormatUint(binary.LittleEndian.Uint64(padded), 10)
+			}
+			copy(padded[8-len(data):], data)
+			return strconv.FormatUint(binary.BigEndian.Uint64(padded), 10)
+		}
+
+		return ""
+	})
+
+	for i := len(groups) - 1; i > 0; i-- {
+		template = strings.ReplaceAll(template, fmt.Sprintf("$%d", i), groups[i])
+	}
+
+	return strings.TrimSpace(template)
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Small database exercising every directive understood
+const testServiceProbes = `# Test probes
+Exclude 9100-9102,U:53,T:9200
+
+Probe TCP NULL q||
+totalwaitms 6000
+match ftp m/^220 ProFTPD (\d[\w.]+) Server/ p/ProFTPD/ v/$1/ cpe:/a:proftpd:proftpd:$1/a
+match ftp m/^220 (\w+) FTP echo \1/ p/Backreference/
+softmatch ftp m/^220 /
+match latin m|^\xe9t\xe9 (\d+)| p/Latin1/ v/$1/
+
+Probe TCP GetRequest q|GET / HTTP/1.0\r\n\r\n|
+rarity 1
+ports 80-82,8080
+sslports 443
+fallback NULL
+match http m|^HTTP/1\.[01] \d\d\d .*\r\nServer: Apache/([\d.]+) \(([^)]+)\)|s p/Apache httpd/ v/$1/ i/$SUBST(2,"_"," ")/
+match redis m|^-ERR .*\r\n(..)| p/Redis/ i/protocol $I(1,">")/ cpe:/a:redis:redis/
+
+Probe UDP DNSStatusRequest q|\0\0\x10\0\0\0\0\0\0\0\0\0|
+rarity 8
+ports 53
+`
+
+func TestParseServiceProbes(t *testing.T) {
+	database, err := parseServiceProbes(strings.NewReader(testServiceProbes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(database.probes) != 3 {
+		t.Fatalf("%d probes parsed, want 3", len(database.probes))
+	}
+
+	for _, port := range []int{9100, 9101, 9102, 9200} {
+		if !database.excluded[port] {
+			t.Errorf("port %d should be excluded", port)
+		}
+	}
+	if database.excluded[53] {
+		t.Error("UDP exclusions should not exclude TCP ports")
+	}
+
+	null, get, dns := database.probes[0], database.probes[1], database.probes[2]
+
+	if len(null.payload) != 0 || null.rarity != 1 || null.wait != 6*time.Second {
+		t.Errorf("NULL probe is %q rarity %d wait %s", null.payload, null.rarity, null.wait)
+	}
+
+	// The backreference cannot be compiled by Go and is skipped
+	var services []string
+	for _, match := range null.matches {
+		services = append(services, match.service)
+	}
+	if want := []string{"ftp", "ftp", "latin"}; !reflect.DeepEqual(services, want) {
+		t.Errorf("NULL matches are %v, want %v", services, want)
+	}
+	if !null.matches[1].soft || null.matches[0].soft {
+		t.Error("only the softmatch should be soft")
+	}
+	if null.matches[0].product != "ProFTPD" || null.matches[0].version != "$1" {
+		t.Errorf("ProFTPD fields are %q %q", null.matches[0].product, null.matches[0].version)
+	}
+
+	if get.payload == nil || !bytes.Equal(get.payload, []byte("GET / HTTP/1.0\r\n\r\n")) {
+		t.Errorf("GetRequest payload is %q", get.payload)
+	}
+	if want := map[int]bool{80: true, 81: true, 82: true, 8080: true}; !reflect.DeepEqual(get.ports, want) {
+		t.Errorf("GetRequest ports are %v", get.ports)
+	}
+	if want := map[int]bool{443: true}; !reflect.DeepEqual(get.sslPorts, want) {
+		t.Errorf("GetRequest sslports are %v", get.sslPorts)
+	}
+	if len(get.fallbacks) != 1 || get.fallbacks[0] != null {
+		t.Errorf("GetRequest fallbacks are %v", get.fallbackNames)
+	}
+
+	if dns.protocol != "UDP" || dns.rarity != 8 || !bytes.Equal(dns.payload, []byte{0, 0, 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("DNSStatusRequest is %s rarity %d payload %q", dns.protocol, dns.rarity, dns.payload)
+	}
+}
+
+func TestParseServiceProbesInvalid(t *testing.T) {
+	for _, database := range []string{"Probe TCP NULL", "Probe TCP NULL x||", "Probe TCP NULL q|unterminated"} {
+		if _, err := parseServiceProbes(strings.NewReader(database)); err == nil {
+			t.Errorf("%q should fail to parse", database)
+		}
+	}
+}
+
+func TestMatchResponse(t *testing.T) {
+	database, err := parseServiceProbes(strings.NewReader(testServiceProbes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	null, get := database.probes[0], database.probes[1]
+
+	tests := []struct {
+		name     string
+		sent     *serviceProbe
+		response string
+		want     serviceVersion
+	}{
+		{"hard match", null, "220 ProFTPD 1.3.5e Server (Debian)\r\n", serviceVersion{service: "ftp", product: "ProFTPD", version: "1.3.5e"}},
+		{"soft match", null, "220 Welcome\r\n", serviceVersion{service: "ftp", soft: true}},
+		{"latin1 bytes", null, "\xe9t\xe9 42", serviceVersion{service: "latin", product: "Latin1", version: "42"}},
+		{"SUBST template", get, "HTTP/1.1 200 OK\r\nServer: Apache/2.4.57 (Debian_GNU_Linux)\r\n\r\n", serviceVersion{service: "http", product: "Apache httpd", version: "2.4.57", info: "Debian GNU Linux"}},
+		{"I template", get, "-ERR unknown\r\n\x01\x02", serviceVersion{service: "redis", product: "Redis", info: "protocol 258"}},
+		{"fallback to NULL", get, "220 ProFTPD 1.3.8 Server ready\r\n", serviceVersion{service: "ftp", product: "ProFTPD", version: "1.3.8"}},
+	}
+
+	for _, test := range tests {
+		got, ok := database.matchResponse(test.sent, []byte(test.response))
+		if !ok {
+			t.Errorf("%s: no match", test.name)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+
+	if got, ok := database.matchResponse(get, []byte("SSH-2.0-OpenSSH_9.6\r\n")); ok {
+		t.Errorf("unknown response matched %+v", got)
+	}
+}
+
+func TestUnescapePayload(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    []byte
+	}{
+		{`GET / HTTP/1.0\r\n\r\n`, []byte("GET / HTTP/1.0\r\n\r\n")},
+		{`\0\x01\xff\t`, []byte{0, 1, 0xff, '\t'}},
+		{`\x4`, []byte("x4")},
+		{`\\|\q`, []byte(`\|q`)},
+		{`trailing\`, []byte(`trailing\`)},
+	}
+
+	for _, test := range tests {
+		if got := unescapePayload(test.payload); !bytes.Equal(got, test.want) {
+			t.Errorf("unescapePayload(%q) = %q, want %q", test.payload, got, test.want)
+		}
+	}
+}
+
+func TestParsePortList(t *testing.T) {
+	tests := []struct {
+		list string
+		want []int
+	}{
+		{"21", []int{21}},
+		{"21, 80-82", []int{21, 80, 81, 82}},
+		{"T:443,U:53,T:8000-8001", []int{443, 8000, 8001}},
+		{"65534-70000", []int{65534, 65535}},
+		{"x,22-y,25", []int{25}},
+	}
+
+	for _, test := range tests {
+		want := make(map[int]bool)
+		for _, port := range test.want {
+			want[port] = true
+		}
+
+		if got := parsePortList(test.list); !reflect.DeepEqual(got, want) {
+			t.Errorf("parsePortList(%q) = %v, want %v", test.list, got, want)
+		}
+	}
+}
+
+func TestFillTemplate(t *testing.T) {
+	groups := []string{"whole", "2.4.57", "Debian_GNU_Linux", "\x00\x01", "ab\x07c"}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"$1", "2.4.57"},
+		{"version $1 on $2", "version 2.4.57 on Debian_GNU_Linux"},
+		{`$SUBST(2,"_"," ")`, "Debian GNU Linux"},
+		{`$I(3,">")`, "1"},
+		{`$I(3,"<")`, "256"},
+		{"$P(4)", "abc"},
+		{"$9", "$9"},
+		{`$P(9)`, ""},
+		{"  padded  ", "padded"},
+	}
+
+	for _, test := range tests {
+		if got := fillTemplate(test.template, groups); got != test.want {
+			t.Errorf("fillTemplate(%q) = %q, want %q", test.template, got, test.want)
+		}
+	}
+}
+
+func TestBundledServiceProbes(t *testing.T) {
+	database, err := parseServiceProbes(strings.NewReader(serviceProbesTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(database.probes) == 0 || len(database.probes[0].payload) != 0 {
+		t.Error("the bundled database should start with the NULL probe")
+	}
+
+	for _, probe := range database.probes {
+		if len(probe.fallbackNames) != len(probe.fallbacks) {
+			t.Errorf("probe %s has unresolved fallbacks %v", probe.name, probe.fallbackNames)
+		}
+	}
+}
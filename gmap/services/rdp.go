@@ -0,0 +1,162 @@
+package services
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gmap/utils"
+	"io"
+	"net"
+	"time"
+)
+
+// RDP negotiation parameters
+const (
+	rdpNegRequest  = 0x01
+	rdpNegResponse = 0x02
+	rdpNegFailure  = 0x03
+	// Connection confirm TPDU code
+	x224ConnectionConfirm = 0xd0
+)
+
+// Security protocols requested in turn, with the protocol the server has to select for them to be supported
+var rdpProtocols = []struct {
+	name      string
+	requested uint32
+	selected  uint32
+}{
+	{"RDP", 0x00, 0x00},
+	{"TLS", 0x01, 0x01},
+	{"CredSSP", 0x03, 0x02},
+	{"CredSSP Early User Auth", 0x0b, 0x08},
+	{"RDSTLS", 0x04, 0x04},
+}
+
+// Failure codes of negotiation failures
+var rdpFailures = map[uint32]string{
+	0x01: "SSL required by server",
+	0x02: "SSL not allowed by server",
+	0x03: "SSL certificate not on server",
+	0x04: "inconsistent flags",
+	0x05: "CredSSP required by server",
+	0x06: "SSL with user auth required by server",
+}
+
+// Refusal of the security protocols requested, sent by servers speaking RDP
+type rdpFailure string
+
+func (f rdpFailure) Error() string {
+	return string(f)
+}
+
+// Auxiliary function to send an X.224 connection request asking for security protocols, returning the protocol selected
+func negotiateRDP(conn net.Conn, protocols uint32) (uint32, error) {
+	cookie := []byte("Cookie: mstshash=gmap\r\n")
+	negotiation := []byte{rdpNegRequest, 0, 8, 0}
+	negotiation = binary.LittleEndian.AppendUint32(negotiation, protocols)
+
+	// Connection request TPDU without references nor class options
+	tpdu := append([]byte{byte(6 + len(cookie) + len(negotiation)), 0xe0, 0, 0, 0, 0, 0}, cookie...)
+	tpdu = append(tpdu, negotiation...)
+
+	// TPKT header
+	request := binary.BigEndian.AppendUint16([]byte{3, 0}, uint16(4+len(tpdu)))
+	if _, err := conn.Write(append(request, tpdu...)); err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, err
+	}
+
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if header[0] != 3 || length < 4+7 || length > maxResponseSize {
+		return 0, errors.New("invalid TPKT")
+	}
+
+	response := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return 0, err
+	}
+
+	if response[1]&0xf0 != x224ConnectionConfirm {
+		return 0, errors.New("connection not confirmed")
+	}
+
+	// Servers older than RDP 5.2 do not answer the negotiation and only speak standard RDP
+	if len(response) < 7+8 {
+		return 0, nil
+	}
+
+	negotiation = response[7:]
+	value := binary.LittleEndian.Uint32(negotiation[4:])
+
+	switch negotiation[0] {
+	case rdpNegResponse:
+		return value, nil
+	case rdpNegFailure:
+		if reason, ok := rdpFailures[value]; ok {
+			return 0, rdpFailure(reason)
+		}
+		return 0, rdpFailure(fmt.Sprintf("negotiation failure %d", value))
+	}
+
+	return 0, errors.New("invalid negotiation")
+}
+
+// Negotiate every security protocol with an RDP server, and inspect the certificate of the first one wrapped in TLS
+func enumerateRDP(address string, port *utils.Port, scan utils.ScanParameters) {
+	info := &utils.RDPInfo{}
+	answered := false
+
+	for _, protocol := range rdpProtocols {
+		conn, err := net.DialTimeout("tcp", address, scan.Timeout)
+		if err != nil {
+			return
+		}
+		conn.SetDeadline(time.Now().Add(scan.Timeout + probeReadTimeout))
+
+		selected, err := negotiateRDP(conn, protocol.requested)
+		if err != nil {
+			conn.Close()
+			// Other errors than refusals mean the server is not RDP
+			if failure, ok := err.(rdpFailure); ok {
+				answered = true
+				info.Failures = append(info.Failures, fmt.Sprintf("%s: %s", protocol.name, failure))
+			}
+			continue
+		}
+		answered = true
+
+		if selected != protocol.selected {
+			conn.Close()
+			continue
+		}
+		info.SecurityProtocols = append(info.SecurityProtocols, protocol.name)
+
+		// Every protocol but standard RDP continues with a TLS handshake
+		if selected != 0 && info.TLSSession == nil {
+			tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, CipherSuites: allCipherSuites()})
+			if err := tlsConn.Handshake(); err == nil {
+				info.TLSSession = describeTLS(tlsConn.ConnectionState())
+			}
+		}
+		conn.Close()
+	}
+
+	if !answered {
+		return
+	}
+
+	// Network Level Authentication is required when neither standard RDP nor plain TLS is accepted
+	info.NLARequired = len(info.SecurityProtocols) > 0
+	for _, protocol := range info.SecurityProtocols {
+		if protocol == "RDP" || protocol == "TLS" {
+			info.NLARequired = false
+		}
+	}
+
+	port.RDP = info
+}
@@ -0,0 +1,394 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gmap/utils"
+	"io"
+	"net"
+	"time"
+	"unicode/utf16"
+)
+
+// SMB parameters
+const (
+	smb2Negotiate    = 0x0000
+	smb2SessionSetup = 0x0001
+	// Security mode bits of SMB2 and SMB1 negotiate responses
+	smb2SigningEnabled  = 0x01
+	smb2SigningRequired = 0x02
+	smb1SigningEnabled  = 0x04
+	smb1SigningRequired = 0x08
+	// Largest SMB message accepted
+	smbMaxMessage = 1 << 20
+	// NetBIOS session service packet types
+	netbiosSessionMessage  = 0x00
+	netbiosSessionRequest  = 0x81
+	netbiosSessionAccepted = 0x82
+	netbiosSessionPort     = 139
+)
+
+// SMB2 dialects and their names, in ascending order
+var smb2Dialects = []struct {
+	revision uint16
+	name     string
+}{
+	{0x0202, "SMB 2.0.2"},
+	{0x0210, "SMB 2.1"},
+	{0x0300, "SMB 3.0"},
+	{0x0302, "SMB 3.0.2"},
+	{0x0311, "SMB 3.1.1"},
+}
+
+// NTLMSSP AV pair identifiers of the target information
+const (
+	avNbComputerName  = 1
+	avNbDomainName    = 2
+	avDnsComputerName = 3
+	avDnsDomainName   = 4
+	avDnsTreeName     = 5
+)
+
+// NTLMSSP NEGOTIATE message asking for the target information, with a Windows 7 version field
+var ntlmNegotiate = []byte{
+	'N', 'T', 'L', 'M', 'S', 'S', 'P', 0,
+	1, 0, 0, 0,
+	// Unicode, OEM, request target, NTLM, always sign, extended session security, version, 128 and 56 bit
+	0x07, 0x82, 0x08, 0xa2,
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0,
+	6, 1, 0xb1, 0x1d, 0, 0, 0, 0x0f,
+}
+
+// Auxiliary function to encode a DER length
+func derLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+
+	var encoded []byte
+	for ; length > 0; length >>= 8 {
+		encoded = append([]byte{byte(length)}, encoded...)
+	}
+
+	return append([]byte{0x80 | byte(len(encoded))}, encoded...)
+}
+
+// Auxiliary function to wrap DER content in a tag
+func derWrap(tag byte, content []byte) []byte {
+	return append(append([]byte{tag}, derLength(len(content))...), content...)
+}
+
+// Auxiliary function to wrap an NTLMSSP token in a SPNEGO NegTokenInit
+func spnegoWrap(token []byte) []byte {
+	spnegoOid := []byte{0x06, 0x06, 0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}
+	ntlmOid := []byte{0x06, 0x0a, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+
+	mechTypes := derWrap(0xa0, derWrap(0x30, ntlmOid))
+	mechToken := derWrap(0xa2, derWrap(0x04, token))
+	negTokenInit := derWrap(0xa0, derWrap(0x30, append(mechTypes, mechToken...)))
+
+	return derWrap(0x60, append(spnegoOid, negTokenInit...))
+}
+
+// SMB connection, direct over TCP or through a NetBIOS session
+type smbConn struct {
+	conn      net.Conn
+	messageId uint64
+}
+
+// Auxiliary function to open an SMB connection, starting a NetBIOS session on port 139
+func dialSMB(address string, port int, timeout time.Duration) (*smbConn, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout + probeReadTimeout))
+
+	c := &smbConn{conn: conn}
+
+	if port == netbiosSessionPort {
+		// Servers accept the generic *SMBSERVER name when the called name is unknown
+		names := append(encodeNetBIOSName("*SMBSERVER", ' ', 0x20), encodeNetBIOSName("GMAP", ' ', 0)...)
+		request := append([]byte{netbiosSessionRequest, 0, 0, byte(len(names))}, names...)
+
+		if _, err := conn.Write(request); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		response := make([]byte, 4)
+		if _, err := io.ReadFull(conn, response); err != nil || response[0] != netbiosSessionAccepted {
+			conn.Close()
+			return nil, errors.New("NetBIOS session refused")
+		}
+	}
+
+	return c, nil
+}
+
+// Send an SMB message and read the reply
+func (c *smbConn) exchange(message []byte) ([]byte, error) {
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(message)))
+	frame[0] = netbiosSessionMessage
+
+	if _, err := c.conn.Write(append(frame, message...)); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header) & 0xffffff
+	if length > smbMaxMessage {
+		return nil, errors.New("message too long")
+	}
+
+	reply := make([]byte, length)
+	_, err := io.ReadFull(c.conn, reply)
+	return reply, err
+}
+
+// Build an SMB2 header for a command
+func (c *smbConn) smb2Header(command uint16) []byte {
+	header := make([]byte, 64)
+	copy(header, "\xfeSMB")
+	binary.LittleEndian.PutUint16(header[4:], 64)
+	binary.LittleEndian.PutUint16(header[12:], command)
+	// Credits requested
+	binary.LittleEndian.PutUint16(header[14:], 31)
+	binary.LittleEndian.PutUint64(header[24:], c.messageId)
+	c.messageId++
+
+	return header
+}
+
+// Build an SMB2 NEGOTIATE request, SMB 3.1.1 requires the preauth integrity and encryption contexts
+func (c *smbConn) smb2NegotiateRequest(dialects []uint16) []byte {
+	request := c.smb2Header(smb2Negotiate)
+
+	body := binary.LittleEndian.AppendUint16(nil, 36)
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(dialects)))
+	body = binary.LittleEndian.AppendUint16(body, smb2SigningEnabled)
+	// Reserved and capabilities
+	body = append(body, 0, 0, 0, 0, 0, 0)
+	guid := make([]byte, 16)
+	rand.Read(guid)
+	body = append(body, guid...)
+
+	// Negotiate context offset and count, filled in once the dialects are known
+	contextField := len(request) + len(body)
+	body = append(body, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	smb311 := false
+	for _, dialect := range dialects {
+		body = binary.LittleEndian.AppendUint16(body, dialect)
+		smb311 = smb311 || dialect == 0x0311
+	}
+	request = append(request, body...)
+
+	if !smb311 {
+		return request
+	}
+
+	// Contexts are 8 byte aligned
+	for len(request)%8 != 0 {
+		request = append(request, 0)
+	}
+	binary.LittleEndian.PutUint32(request[contextField:], uint32(len(request)))
+	binary.LittleEndian.PutUint16(request[contextField+4:], 2)
+
+	// Preauth integrity with SHA-512 and a random salt
+	salt := make([]byte, 32)
+	rand.Read(salt)
+	preauth := append([]byte{1, 0, 32, 0, 1, 0}, salt...)
+	request = append(request, 1, 0, byte(len(preauth)), 0, 0, 0, 0, 0)
+	request = append(request, preauth...)
+
+	for len(request)%8 != 0 {
+		request = append(request, 0)
+	}
+
+	// Encryption with AES-128-GCM or AES-128-CCM
+	request = append(request, 2, 0, 6, 0, 0, 0, 0, 0)
+	request = append(request, 2, 0, 2, 0, 1, 0)
+
+	return request
+}
+
+// Negotiate SMB2 offering the given dialects, returning the dialect chosen, the security mode and the reply
+func (c *smbConn) negotiateSMB2(dialects []uint16) (uint16, uint16, error) {
+	reply, err := c.exchange(c.smb2NegotiateRequest(dialects))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(reply) < 64+8 || !bytes.HasPrefix(reply, []byte("\xfeSMB")) || binary.LittleEndian.Uint32(reply[8:]) != 0 {
+		return 0, 0, errors.New("negotiation refused")
+	}
+
+	body := reply[64:]
+	return binary.LittleEndian.Uint16(body[4:]), binary.LittleEndian.Uint16(body[2:]), nil
+}
+
+// Negotiate SMB1 offering only the NT LM 0.12 dialect, returning the security mode
+func (c *smbConn) negotiateSMB1() (byte, error) {
+	request := make([]byte, 32)
+	copy(request, "\xffSMB")
+	request[4] = 0x72
+	// Case insensitive paths, canonicalized names
+	request[9] = 0x18
+	// Long names, NT status codes and Unicode
+	binary.LittleEndian.PutUint16(request[10:], 0xc001)
+
+	dialect := []byte("\x02NT LM 0.12\x00")
+	request = append(request, 0)
+	request = binary.LittleEndian.AppendUint16(request, uint16(len(dialect)))
+	request = append(request, dialect...)
+
+	reply, err := c.exchange(request)
+	if err != nil {
+		return 0, err
+	}
+
+	// Word count, dialect index and security mode
+	if len(reply) < 36 || !bytes.HasPrefix(reply, []byte("\xffSMB")) || binary.LittleEndian.Uint32(reply[5:]) != 0 || reply[32] == 0 || binary.LittleEndian.Uint16(reply[33:]) != 0 {
+		return 0, errors.New("negotiation refused")
+	}
+
+	return reply[35], nil
+}
+
+// Send an NTLMSSP NEGOTIATE in a SESSION_SETUP request and return the CHALLENGE of the server
+func (c *smbConn) ntlmChallenge() ([]byte, error) {
+	token := spnegoWrap(ntlmNegotiate)
+	request := c.smb2Header(smb2SessionSetup)
+
+	body := binary.LittleEndian.AppendUint16(nil, 25)
+	// Flags, signing enabled, capabilities and channel
+	body = append(body, 0, smb2SigningEnabled, 0, 0, 0, 0, 0, 0, 0, 0)
+	body = binary.LittleEndian.AppendUint16(body, uint16(64+24))
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(token)))
+	// Previous session
+	body = append(body, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	reply, err := c.exchange(append(append(request, body...), token...))
+	if err != nil {
+		return nil, err
+	}
+
+	start := bytes.Index(reply, []byte("NTLMSSP\x00\x02\x00\x00\x00"))
+	if start < 0 {
+		return nil, errors.New("no NTLMSSP challenge")
+	}
+
+	return reply[start:], nil
+}
+
+// Auxiliary function to decode a UTF-16LE string
+func decodeUTF16(data []byte) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+
+	return string(utf16.Decode(units))
+}
+
+// Parse the host names and OS version of an NTLMSSP CHALLENGE
+func parseNtlmChallenge(info *utils.SMBInfo, challenge []byte) {
+	if len(challenge) < 48 {
+		return
+	}
+
+	length := int(binary.LittleEndian.Uint16(challenge[40:]))
+	offset := int(binary.LittleEndian.Uint32(challenge[44:]))
+
+	if offset+length <= len(challenge) {
+		pairs := challenge[offset : offset+length]
+
+		for len(pairs) >= 4 {
+			id := binary.LittleEndian.Uint16(pairs)
+			size := int(binary.LittleEndian.Uint16(pairs[2:]))
+			if id == 0 || 4+size > len(pairs) {
+				break
+			}
+
+			value := decodeUTF16(pairs[4 : 4+size])
+			switch id {
+			case avNbComputerName:
+				info.NetBIOSName = value
+			case avNbDomainName:
+				info.NetBIOSDomain = value
+			case avDnsComputerName:
+				info.DNSName = value
+			case avDnsDomainName:
+				info.DNSDomain = value
+			case avDnsTreeName:
+				info.DNSForest = value
+			}
+
+			pairs = pairs[4+size:]
+		}
+	}
+
+	// Version of the OS, left empty by servers not filling it (e.g. older Samba)
+	if len(challenge) >= 56 && challenge[48] != 0 {
+		info.OS = fmt.Sprintf("Windows %d.%d Build %d", challenge[48], challenge[49], binary.LittleEndian.Uint16(challenge[50:]))
+	}
+}
+
+// Negotiate every SMB dialect, the signing requirements and the host information sent in the NTLMSSP challenge
+func enumerateSMB(address string, port *utils.Port, scan utils.ScanParameters) {
+	info := &utils.SMBInfo{}
+
+	if c, err := dialSMB(address, port.Port, scan.Timeout); err == nil {
+		if mode, err := c.negotiateSMB1(); err == nil {
+			info.Dialects = append(info.Dialects, "SMB 1.0")
+			info.SigningEnabled = mode&smb1SigningEnabled != 0
+			info.SigningRequired = mode&smb1SigningRequired != 0
+		}
+		c.conn.Close()
+	}
+
+	// Each dialect is offered alone to know which ones are supported
+	for _, dialect := range smb2Dialects {
+		c, err := dialSMB(address, port.Port, scan.Timeout)
+		if err != nil {
+			break
+		}
+
+		if revision, mode, err := c.negotiateSMB2([]uint16{dialect.revision}); err == nil && revision == dialect.revision {
+			info.Dialects = append(info.Dialects, dialect.name)
+			info.SigningEnabled = mode&smb2SigningEnabled != 0
+			info.SigningRequired = mode&smb2SigningRequired != 0
+		}
+		c.conn.Close()
+	}
+
+	if len(info.Dialects) == 0 {
+		return
+	}
+
+	// The challenge is sent before authenticating, by any SMB2 server
+	if c, err := dialSMB(address, port.Port, scan.Timeout); err == nil {
+		var dialects []uint16
+		for _, dialect := range smb2Dialects {
+			dialects = append(dialects, dialect.revision)
+		}
+
+		if _, _, err := c.negotiateSMB2(dialects); err == nil {
+			if challenge, err := c.ntlmChallenge(); err == nil {
+				parseNtlmChallenge(info, challenge)
+			}
+		}
+		c.conn.Close()
+	}
+
+	port.SMB = info
+}
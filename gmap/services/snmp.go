@@ -0,0 +1,434 @@
+package services
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gmap/utils"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Communities tried by default, the factory defaults of most agents
+const DefaultSNMPCommunities = "public,private"
+
+// SNMP parameters
+const (
+	snmpVersion1  = 0
+	snmpVersion2c = 1
+	snmpVersion3  = 3
+	// PDU types
+	snmpGetRequest     = 0xa0
+	snmpGetNextRequest = 0xa1
+	snmpResponse       = 0xa2
+	// BER types
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berNull        = 0x05
+	berOid         = 0x06
+	berSequence    = 0x30
+	// Interfaces walked at most
+	maxSNMPInterfaces = 64
+	// Reportable flag and user-based security model of SNMPv3 messages
+	snmpReportable = 0x04
+	snmpUSM        = 3
+)
+
+// System group objects
+const (
+	oidSysDescr    = "1.3.6.1.2.1.1.1.0"
+	oidSysUpTime   = "1.3.6.1.2.1.1.3.0"
+	oidSysContact  = "1.3.6.1.2.1.1.4.0"
+	oidSysName     = "1.3.6.1.2.1.1.5.0"
+	oidIfDescr     = "1.3.6.1.2.1.2.2.1.2"
+	oidIfPhys      = "1.3.6.1.2.1.2.2.1.6"
+	oidIfOperState = "1.3.6.1.2.1.2.2.1.8"
+)
+
+// Operational states of interfaces
+var ifOperStates = map[int64]string{1: "up", 2: "down", 3: "testing", 4: "unknown", 5: "dormant", 6: "notPresent", 7: "lowerLayerDown"}
+
+// Element of a BER encoding
+type berElement struct {
+	tag   byte
+	value []byte
+}
+
+// Variable binding of an SNMP response
+type snmpVarBind struct {
+	oid   string
+	value berElement
+}
+
+// Auxiliary function to read a BER element
+func parseBER(data []byte) (berElement, []byte, error) {
+	if len(data) < 2 {
+		return berElement{}, nil, errors.New("truncated element")
+	}
+
+	tag := data[0]
+	length := int(data[1])
+	offset := 2
+
+	if length&0x80 != 0 {
+		size := length & 0x7f
+		if size == 0 || size > 4 || len(data) < offset+size {
+			return berElement{}, nil, errors.New("invalid length")
+		}
+
+		length = 0
+		for _, b := range data[offset : offset+size] {
+			length = length<<8 | int(b)
+		}
+		offset += size
+	}
+
+	if length < 0 || len(data) < offset+length {
+		return berElement{}, nil, errors.New("truncated element")
+	}
+
+	return berElement{tag: tag, value: data[offset : offset+length]}, data[offset+length:], nil
+}
+
+// Auxiliary function to read every element of a constructed value
+func parseBERSequence(data []byte) ([]berElement, error) {
+	var elements []berElement
+
+	for len(data) > 0 {
+		element, rest, err := parseBER(data)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+		data = rest
+	}
+
+	return elements, nil
+}
+
+// Auxiliary function to encode an integer
+func berInt(value int64) []byte {
+	var encoded []byte
+	for {
+		encoded = append([]byte{byte(value)}, encoded...)
+		value >>= 8
+		// Stop once the sign bit of the first byte matches the rest of the value
+		if (value == 0 && encoded[0]&0x80 == 0) || (value == -1 && encoded[0]&0x80 != 0) {
+			break
+		}
+	}
+
+	return derWrap(berInteger, encoded)
+}
+
+// Auxiliary function to decode an integer, counters and time ticks included
+func decodeBERInt(value []byte) int64 {
+	var decoded int64
+	if len(value) > 0 && value[0]&0x80 != 0 {
+		decoded = -1
+	}
+
+	for _, b := range value {
+		decoded = decoded<<8 | int64(b)
+	}
+
+	return decoded
+}
+
+// Auxiliary function to encode a dotted OID
+func berOID(oid string) []byte {
+	parts := strings.Split(oid, ".")
+	var numbers []int
+	for _, part := range parts {
+		number, _ := strconv.Atoi(part)
+		numbers = append(numbers, number)
+	}
+
+	encoded := []byte{byte(numbers[0]*40 + numbers[1])}
+	for _, number := range numbers[2:] {
+		// Base 128 digits, every one but the last with the high bit set
+		sub := []byte{byte(number & 0x7f)}
+		for number >>= 7; number > 0; number >>= 7 {
+			sub = append([]byte{byte(number&0x7f | 0x80)}, sub...)
+		}
+		encoded = append(encoded, sub...)
+	}
+
+	return derWrap(berOid, encoded)
+}
+
+// Auxiliary function to decode an OID to its dotted form
+func decodeBEROID(value []byte) string {
+	if len(value) == 0 {
+		return ""
+	}
+
+	parts := []string{strconv.Itoa(int(value[0]) / 40), strconv.Itoa(int(value[0]) % 40)}
+	number := 0
+	for _, b := range value[1:] {
+		number = number<<7 | int(b&0x7f)
+		if b&0x80 == 0 {
+			parts = append(parts, strconv.Itoa(number))
+			number = 0
+		}
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// Build a v1 or v2c request for a list of objects
+func snmpRequest(version int, community string, pdu byte, requestId int64, oids []string) []byte {
+	var bindings []byte
+	for _, oid := range oids {
+		bindings = append(bindings, derWrap(berSequence, append(berOID(oid), berNull, 0))...)
+	}
+
+	body := append(berInt(requestId), berInt(0)...)
+	body = append(body, berInt(0)...)
+	body = append(body, derWrap(berSequence, bindings)...)
+
+	message := append(berInt(int64(version)), derWrap(berOctetString, []byte(community))...)
+	message = append(message, derWrap(pdu, body)...)
+
+	return derWrap(berSequence, message)
+}
+
+// Send a v1 or v2c request and return the variable bindings of the response
+func snmpExchange(conn net.Conn, version int, community string, pdu byte, oids []string, timeout time.Duration) ([]snmpVarBind, error) {
+	requestId := int64(rand.Int31())
+	conn.SetDeadline(time.Now().Add(timeout + probeReadTimeout))
+
+	if _, err := conn.Write(snmpRequest(version, community, pdu, requestId, oids)); err != nil {
+		return nil, err
+	}
+
+	buffer := make([]byte, 65535)
+
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+
+		message, _, err := parseBER(buffer[:n])
+		if err != nil || message.tag != berSequence {
+			continue
+		}
+
+		fields, err := parseBERSequence(message.value)
+		if err != nil || len(fields) < 3 || fields[2].tag != snmpResponse {
+			continue
+		}
+
+		body, err := parseBERSequence(fields[2].value)
+		if err != nil || len(body) < 4 || decodeBERInt(body[0].value) != requestId {
+			continue
+		}
+
+		if status := decodeBERInt(body[1].value); status != 0 {
+			return nil, fmt.Errorf("error status %d", status)
+		}
+
+		bindings, err := parseBERSequence(body[3].value)
+		if err != nil {
+			return nil, err
+		}
+
+		var varBinds []snmpVarBind
+		for _, binding := range bindings {
+			pair, err := parseBERSequence(binding.value)
+			if err != nil || len(pair) < 2 || pair[0].tag != berOid {
+				continue
+			}
+			varBinds = append(varBinds, snmpVarBind{oid: decodeBEROID(pair[0].value), value: pair[1]})
+		}
+
+		return varBinds, nil
+	}
+}
+
+// Auxiliary function to get a printable value of a binding
+func (b snmpVarBind) String() string {
+	switch b.value.tag {
+	case berOctetString:
+		return strings.TrimRight(string(b.value.value), "\x00")
+	case berOid:
+		return decodeBEROID(b.value.value)
+	}
+
+	return strconv.FormatInt(decodeBERInt(b.value.value), 10)
+}
+
+// Auxiliary function to check if a binding holds a value, v2c agents answer missing objects with exceptions
+func (b snmpVarBind) exists() bool {
+	return b.value.tag != berNull && b.value.tag < 0x80
+}
+
+// Walk the interface table, with one request per interface for its address and state
+func walkInterfaces(conn net.Conn, version int, community string, timeout time.Duration) []utils.SNMPInterface {
+	var interfaces []utils.SNMPInterface
+	oid := oidIfDescr
+
+	for len(interfaces) < maxSNMPInterfaces {
+		bindings, err := snmpExchange(conn, version, community, snmpGetNextRequest, []string{oid}, timeout)
+		if err != nil || len(bindings) == 0 || !bindings[0].exists() || !strings.HasPrefix(bindings[0].oid, oidIfDescr+".") {
+			break
+		}
+
+		oid = bindings[0].oid
+		index := strings.TrimPrefix(oid, oidIfDescr+".")
+		number, _ := strconv.Atoi(index)
+		iface := utils.SNMPInterface{Index: number, Description: bindings[0].String()}
+
+		if details, err := snmpExchange(conn, version, community, snmpGetRequest, []string{oidIfPhys + "." + index, oidIfOperState + "." + index}, timeout); err == nil {
+			for _, detail := range details {
+				if !detail.exists() {
+					continue
+				}
+
+				switch {
+				case strings.HasPrefix(detail.oid, oidIfPhys+".") && len(detail.value.value) == 6:
+					iface.MAC = net.HardwareAddr(detail.value.value).String()
+				case strings.HasPrefix(detail.oid, oidIfOperState+"."):
+					iface.Status = ifOperStates[decodeBERInt(detail.value.value)]
+				}
+			}
+		}
+
+		interfaces = append(interfaces, iface)
+	}
+
+	return interfaces
+}
+
+// Query the system group and interfaces with a community, returning false when the agent does not answer
+func querySNMP(conn net.Conn, info *utils.SNMPInfo, version int, community string, timeout time.Duration) bool {
+	bindings, err := snmpExchange(conn, version, community, snmpGetRequest, []string{oidSysDescr, oidSysUpTime, oidSysContact, oidSysName}, timeout)
+	if err != nil {
+		return false
+	}
+
+	for _, binding := range bindings {
+		if !binding.exists() {
+			continue
+		}
+
+		switch binding.oid {
+		case oidSysDescr:
+			info.SysDescr = binding.String()
+		case oidSysUpTime:
+			info.SysUpTimeTicks = decodeBERInt(binding.value.value)
+		case oidSysContact:
+			info.SysContact = binding.String()
+		case oidSysName:
+			info.SysName = binding.String()
+		}
+	}
+
+	info.Interfaces = walkInterfaces(conn, version, community, timeout)
+	return true
+}
+
+// Send an SNMPv3 discovery request, answered with a report holding the engine ID, boots and time of the agent
+func discoverEngine(conn net.Conn, info *utils.SNMPInfo, timeout time.Duration) bool {
+	messageId := int64(rand.Int31())
+
+	header := append(berInt(messageId), berInt(65507)...)
+	header = append(header, derWrap(berOctetString, []byte{snmpReportable})...)
+	header = append(header, berInt(snmpUSM)...)
+
+	// Empty engine ID, boots, time, user and authentication parameters
+	var usm []byte
+	usm = append(usm, derWrap(berOctetString, nil)...)
+	usm = append(usm, berInt(0)...)
+	usm = append(usm, berInt(0)...)
+	for i := 0; i < 3; i++ {
+		usm = append(usm, derWrap(berOctetString, nil)...)
+	}
+
+	pdu := append(berInt(int64(rand.Int31())), berInt(0)...)
+	pdu = append(pdu, berInt(0)...)
+	pdu = append(pdu, derWrap(berSequence, nil)...)
+
+	scoped := append(derWrap(berOctetString, nil), derWrap(berOctetString, nil)...)
+	scoped = append(scoped, derWrap(snmpGetRequest, pdu)...)
+
+	message := append(berInt(snmpVersion3), derWrap(berSequence, header)...)
+	message = append(message, derWrap(berOctetString, derWrap(berSequence, usm))...)
+	message = append(message, derWrap(berSequence, scoped)...)
+
+	conn.SetDeadline(time.Now().Add(timeout + probeReadTimeout))
+	if _, err := conn.Write(derWrap(berSequence, message)); err != nil {
+		return false
+	}
+
+	buffer := make([]byte, 65535)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return false
+	}
+
+	response, _, err := parseBER(buffer[:n])
+	if err != nil {
+		return false
+	}
+
+	fields, err := parseBERSequence(response.value)
+	if err != nil || len(fields) < 3 || decodeBERInt(fields[0].value) != snmpVersion3 {
+		return false
+	}
+
+	params, _, err := parseBER(fields[2].value)
+	if err != nil {
+		return false
+	}
+
+	security, err := parseBERSequence(params.value)
+	if err != nil || len(security) < 3 || len(security[0].value) == 0 {
+		return false
+	}
+
+	info.EngineID = hex.EncodeToString(security[0].value)
+	info.EngineBoots = int(decodeBERInt(security[1].value))
+	info.EngineTimeSeconds = decodeBERInt(security[2].value)
+
+	return true
+}
+
+// Try the communities over v2c and v1, and discover the SNMPv3 engine of the agent
+func enumerateSNMP(address string, port *utils.Port, scan utils.ScanParameters) {
+	conn, err := net.DialTimeout("udp", address, scan.Timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	info := &utils.SNMPInfo{}
+	communities := scan.SNMPCommunities
+	if len(communities) == 0 {
+		communities = strings.Split(DefaultSNMPCommunities, ",")
+	}
+
+	for _, community := range communities {
+		if querySNMP(conn, info, snmpVersion2c, community, scan.Timeout) {
+			info.Version, info.Community = "v2c", community
+			break
+		}
+
+		if querySNMP(conn, info, snmpVersion1, community, scan.Timeout) {
+			info.Version, info.Community = "v1", community
+			break
+		}
+	}
+
+	if discoverEngine(conn, info, scan.Timeout) && info.Version == "" {
+		info.Version = "v3"
+	}
+
+	if info.Version != "" {
+		port.SNMP = info
+	}
+}
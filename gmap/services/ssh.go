@@ -0,0 +1,434 @@
+package services
+
+import (
+	"bufio"
+	"crypto/ecdh"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gmap/utils"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// SSH message numbers
+const (
+	sshMsgKexInit     = 20
+	sshMsgKexEcdhInit = 30
+	sshMsgKexEcdhRepl = 31
+	// Longest packet accepted, as required by RFC 4253
+	sshMaxPacket = 35000
+)
+
+// Identification sent to servers
+const sshClientVersion = "SSH-2.0-gmap"
+
+// Key exchanges offered to fetch host keys, all based on ECDH
+var sshKexAlgorithms = []string{"curve25519-sha256", "curve25519-sha256@libssh.org", "ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521"}
+
+// Curve of every key exchange offered
+var sshKexCurves = map[string]ecdh.Curve{
+	"curve25519-sha256":            ecdh.X25519(),
+	"curve25519-sha256@libssh.org": ecdh.X25519(),
+	"ecdh-sha2-nistp256":           ecdh.P256(),
+	"ecdh-sha2-nistp384":           ecdh.P384(),
+	"ecdh-sha2-nistp521":           ecdh.P521(),
+}
+
+// Host key algorithms fetched, RSA signature variants share the same key
+var sshHostKeyAlgorithms = []string{"ssh-ed25519", "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521", "rsa-sha2-512", "rsa-sha2-256", "ssh-rsa", "ssh-dss"}
+
+// Algorithms considered weak, broken ciphers and MACs or SHA-1 based exchanges and signatures
+var sshWeakAlgorithms = map[string]bool{
+	"diffie-hellman-group1-sha1":         true,
+	"diffie-hellman-group14-sha1":        true,
+	"diffie-hellman-group-exchange-sha1": true,
+	"ssh-dss":                            true,
+	"ssh-rsa":                            true,
+	"arcfour":                            true,
+	"arcfour128":                         true,
+	"arcfour256":                         true,
+	"3des-cbc":                           true,
+	"blowfish-cbc":                       true,
+	"cast128-cbc":                        true,
+	"aes128-cbc":                         true,
+	"aes192-cbc":                         true,
+	"aes256-cbc":                         true,
+	"rijndael-cbc@lysator.liu.se":        true,
+	"hmac-md5":                           true,
+	"hmac-md5-96":                        true,
+	"hmac-md5-etm@openssh.com":           true,
+	"hmac-md5-96-etm@openssh.com":        true,
+	"hmac-sha1-96":                       true,
+	"hmac-sha1-96-etm@openssh.com":       true,
+	"umac-64@openssh.com":                true,
+	"umac-64-etm@openssh.com":            true,
+	"none":                               true,
+}
+
+// Algorithm lists of a KEXINIT message
+type sshKexInit struct {
+	kex         []string
+	hostKey     []string
+	ciphers     []string
+	macs        []string
+	compression []string
+}
+
+// SSH connection in the clear, before keys are exchanged
+type sshConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Auxiliary function to append an SSH string
+func appendSSHString(buffer []byte, value []byte) []byte {
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(len(value)))
+	return append(buffer, value...)
+}
+
+// Auxiliary function to read an SSH string
+func readSSHString(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, errors.New("truncated string")
+	}
+
+	length := binary.BigEndian.Uint32(data)
+	if uint32(len(data)-4) < length {
+		return nil, nil, errors.New("truncated string")
+	}
+
+	return data[4 : 4+length], data[4+length:], nil
+}
+
+// Auxiliary function to merge two name lists keeping the order
+func mergeNameLists(first []string, second []string) []string {
+	var merged []string
+	seen := make(map[string]bool)
+
+	for _, list := range [][]string{first, second} {
+		for _, name := range list {
+			if !seen[name] {
+				seen[name] = true
+				merged = append(merged, name)
+			}
+		}
+	}
+
+	return merged
+}
+
+// Read the identification line of the server, ignoring the lines servers may send before it
+func (c *sshConn) readVersion() (string, error) {
+	for i := 0; i < 20; i++ {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		if line = strings.TrimRight(line, "\r\n"); strings.HasPrefix(line, "SSH-") {
+			return line, nil
+		}
+	}
+
+	return "", errors.New("no SSH identification")
+}
+
+// Write an unencrypted binary packet
+func (c *sshConn) writePacket(payload []byte) error {
+	// Padding of at least 4 bytes so the packet is a multiple of 8
+	padding := 8 - (len(payload)+5)%8
+	if padding < 4 {
+		padding += 8
+	}
+
+	packet := binary.BigEndian.AppendUint32(nil, uint32(1+len(payload)+padding))
+	packet = append(packet, byte(padding))
+	packet = append(packet, payload...)
+	packet = append(packet, make([]byte, padding)...)
+	rand.Read(packet[len(packet)-padding:])
+
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+// Read an unencrypted binary packet and return its payload
+func (c *sshConn) readPacket() ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	padding := uint32(header[4])
+	if length < padding+1 || length > sshMaxPacket {
+		return nil, errors.New("invalid packet length")
+	}
+
+	packet := make([]byte, length-1)
+	if _, err := io.ReadFull(c.reader, packet); err != nil {
+		return nil, err
+	}
+
+	return packet[:len(packet)-int(padding)], nil
+}
+
+// Read packets until one of the given type arrives
+func (c *sshConn) readMessage(message byte) ([]byte, error) {
+	for i := 0; i < 10; i++ {
+		payload, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(payload) > 0 && payload[0] == message {
+			return payload, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no message %d received", message)
+}
+
+// Build a KEXINIT message offering the given algorithms
+func buildKexInit(kex []string, hostKey []string) []byte {
+	ciphers := "aes128-ctr,aes256-ctr,aes128-gcm@openssh.com,chacha20-poly1305@openssh.com"
+	macs := "hmac-sha2-256,hmac-sha2-512,hmac-sha1"
+
+	payload := []byte{sshMsgKexInit}
+	cookie := make([]byte, 16)
+	rand.Read(cookie)
+	payload = append(payload, cookie...)
+
+	for _, list := range []string{strings.Join(kex, ","), strings.Join(hostKey, ","), ciphers, ciphers, macs, macs, "none", "none", "", ""} {
+		payload = appendSSHString(payload, []byte(list))
+	}
+
+	// No guessed packet follows and the reserved field
+	return append(payload, 0, 0, 0, 0, 0)
+}
+
+// Parse the algorithm lists of a KEXINIT message
+func parseKexInit(payload []byte) (sshKexInit, error) {
+	var init sshKexInit
+
+	if len(payload) < 17 {
+		return init, errors.New("truncated KEXINIT")
+	}
+
+	data := payload[17:]
+	lists := make([][]string, 8)
+
+	for i := range lists {
+		value, rest, err := readSSHString(data)
+		if err != nil {
+			return init, err
+		}
+
+		if len(value) > 0 {
+			lists[i] = strings.Split(string(value), ",")
+		}
+		data = rest
+	}
+
+	init.kex = lists[0]
+	init.hostKey = lists[1]
+	init.ciphers = mergeNameLists(lists[2], lists[3])
+	init.macs = mergeNameLists(lists[4], lists[5])
+	init.compression = mergeNameLists(lists[6], lists[7])
+
+	return init, nil
+}
+
+// Auxiliary function to open a connection and exchange identifications and KEXINIT messages
+func sshHandshake(address string, timeout time.Duration, kex []string, hostKey []string) (*sshConn, string, sshKexInit, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, "", sshKexInit{}, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout + probeReadTimeout))
+
+	c := &sshConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if _, err := conn.Write([]byte(sshClientVersion + "\r\n")); err != nil {
+		conn.Close()
+		return nil, "", sshKexInit{}, err
+	}
+
+	version, err := c.readVersion()
+	if err != nil {
+		conn.Close()
+		return nil, "", sshKexInit{}, err
+	}
+
+	if err := c.writePacket(buildKexInit(kex, hostKey)); err != nil {
+		conn.Close()
+		return nil, version, sshKexInit{}, err
+	}
+
+	payload, err := c.readMessage(sshMsgKexInit)
+	if err != nil {
+		conn.Close()
+		return nil, version, sshKexInit{}, err
+	}
+
+	init, err := parseKexInit(payload)
+	if err != nil {
+		conn.Close()
+		return nil, version, sshKexInit{}, err
+	}
+
+	return c, version, init, nil
+}
+
+// Auxiliary function to pick the first algorithm of ours the server supports
+func negotiate(client []string, server []string) string {
+	for _, algorithm := range client {
+		for _, supported := range server {
+			if algorithm == supported {
+				return algorithm
+			}
+		}
+	}
+
+	return ""
+}
+
+// Run an ECDH key exchange far enough to receive the host key of the given algorithm
+func fetchHostKey(address string, timeout time.Duration, algorithm string) ([]byte, error) {
+	c, _, init, err := sshHandshake(address, timeout, sshKexAlgorithms, []string{algorithm})
+	if err != nil {
+		return nil, err
+	}
+	defer c.conn.Close()
+
+	curve, ok := sshKexCurves[negotiate(sshKexAlgorithms, init.kex)]
+	if !ok {
+		return nil, errors.New("no common key exchange")
+	}
+
+	private, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.writePacket(appendSSHString([]byte{sshMsgKexEcdhInit}, private.PublicKey().Bytes())); err != nil {
+		return nil, err
+	}
+
+	reply, err := c.readMessage(sshMsgKexEcdhRepl)
+	if err != nil {
+		return nil, err
+	}
+
+	key, _, err := readSSHString(reply[1:])
+	return key, err
+}
+
+// Auxiliary function to describe a host key blob
+func describeHostKey(blob []byte) utils.SSHHostKey {
+	sha := sha256.Sum256(blob)
+	md := md5.Sum(blob)
+
+	var md5Hex []string
+	for _, b := range md {
+		md5Hex = append(md5Hex, fmt.Sprintf("%02x", b))
+	}
+
+	key := utils.SSHHostKey{
+		SHA256: "SHA256:" + base64.RawStdEncoding.EncodeToString(sha[:]),
+		MD5:    "MD5:" + strings.Join(md5Hex, ":"),
+	}
+
+	keyType, rest, err := readSSHString(blob)
+	if err != nil {
+		return key
+	}
+	key.Type = string(keyType)
+
+	switch key.Type {
+	case "ssh-ed25519":
+		key.Bits = 256
+	case "ssh-rsa", "ssh-dss":
+		// RSA keys hold the exponent and then the modulus, DSA keys start with the prime
+		if key.Type == "ssh-rsa" {
+			if _, rest, err = readSSHString(rest); err != nil {
+				return key
+			}
+		}
+		if modulus, _, err := readSSHString(rest); err == nil {
+			key.Bits = new(big.Int).SetBytes(modulus).BitLen()
+		}
+	case "ecdsa-sha2-nistp256":
+		key.Bits = 256
+	case "ecdsa-sha2-nistp384":
+		key.Bits = 384
+	case "ecdsa-sha2-nistp521":
+		key.Bits = 521
+	}
+
+	return key
+}
+
+// Auxiliary function to split an identification string into protocol version, software and comments
+func parseSSHVersion(info *utils.SSHInfo, version string) {
+	identification, comments, _ := strings.Cut(strings.TrimPrefix(version, "SSH-"), " ")
+	info.ProtocolVersion, info.Software, _ = strings.Cut(identification, "-")
+	info.Comments = comments
+}
+
+// Parse the identification of an SSH server, list its algorithms and fingerprint its host keys
+func enumerateSSH(address string, port *utils.Port, scan utils.ScanParameters) {
+	c, version, init, err := sshHandshake(address, scan.Timeout, sshKexAlgorithms, sshHostKeyAlgorithms)
+	if version == "" {
+		return
+	}
+
+	info := &utils.SSHInfo{}
+	parseSSHVersion(info, version)
+
+	if err == nil {
+		c.conn.Close()
+
+		info.KexAlgorithms = init.kex
+		info.HostKeyAlgorithms = init.hostKey
+		info.Ciphers = init.ciphers
+		info.MACs = init.macs
+		info.Compression = init.compression
+
+		for _, list := range [][]string{init.kex, init.hostKey, init.ciphers, init.macs} {
+			for _, algorithm := range list {
+				if sshWeakAlgorithms[algorithm] {
+					info.WeakAlgorithms = append(info.WeakAlgorithms, algorithm)
+				}
+			}
+		}
+
+		// One exchange per host key, the RSA signature variants all return the same key
+		fetched := make(map[string]bool)
+		for _, algorithm := range sshHostKeyAlgorithms {
+			if negotiate([]string{algorithm}, init.hostKey) == "" {
+				continue
+			}
+
+			blob, err := fetchHostKey(address, scan.Timeout, algorithm)
+			if err != nil {
+				continue
+			}
+
+			key := describeHostKey(blob)
+			if !fetched[key.SHA256] {
+				fetched[key.SHA256] = true
+				info.HostKeys = append(info.HostKeys, key)
+			}
+		}
+	}
+
+	port.SSH = info
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"gmap/utils"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Protocol versions tried when enumerating, SSLv3 and older are not supported by Go
+var tlsVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+// Auxiliary function to offer every cipher suite Go knows, old servers only speak insecure ones
+func allCipherSuites() []uint16 {
+	var suites []uint16
+
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites = append(suites, suite.ID)
+	}
+
+	return suites
+}
+
+// Auxiliary function to complete a TLS handshake restricted to a range of protocol versions
+func tlsHandshake(address string, minVersion uint16, maxVersion uint16, timeout time.Duration) (tls.ConnectionState, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		// Certificates are inspected, not trusted
+		InsecureSkipVerify: true,
+		MinVersion:         minVersion,
+		MaxVersion:         maxVersion,
+		CipherSuites:       allCipherSuites(),
+		NextProtos:         []string{"h2", "http/1.1"},
+	})
+	tlsConn.SetDeadline(time.Now().Add(timeout + probeReadTimeout))
+
+	if err := tlsConn.Handshake(); err != nil {
+		return tls.ConnectionState{}, err
+	}
+
+	return tlsConn.ConnectionState(), nil
+}
+
+// Auxiliary function to get the type and size of a certificate public key
+func publicKeyInfo(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+
+	return cert.PublicKeyAlgorithm.String(), 0
+}
+
+// Auxiliary function to describe a certificate
+func describeCertificate(cert *x509.Certificate) utils.Certificate {
+	keyType, keySize := publicKeyInfo(cert)
+
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	return utils.Certificate{
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		SANs:      sans,
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		KeyType:   keyType,
+		KeySize:   keySize,
+		// Self-signed certificates are issued by their own subject and signed with their own key
+		SelfSigned: bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil,
+	}
+}
+
+// Auxiliary function to describe the version, cipher, ALPN protocol and certificate chain of a session
+func describeTLS(state tls.ConnectionState) *utils.TLSInfo {
+	info := &utils.TLSInfo{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ALPN:        state.NegotiatedProtocol,
+	}
+
+	for _, cert := range state.PeerCertificates {
+		info.Certificates = append(info.Certificates, describeCertificate(cert))
+	}
+
+	return info
+}
+
+// Complete a TLS handshake with a port and describe the session and certificate chain, optionally listing every supported protocol version
+func InspectTLS(address string, timeout time.Duration, enumerate bool) *utils.TLSInfo {
+	state, err := tlsHandshake(address, tls.VersionTLS10, tls.VersionTLS13, timeout)
+	if err != nil {
+		return nil
+	}
+
+	info := describeTLS(state)
+
+	if enumerate {
+		for _, version := range tlsVersions {
+			if _, err := tlsHandshake(address, version, version, timeout); err == nil {
+				info.SupportedVersions = append(info.SupportedVersions, tls.VersionName(version))
+			}
+		}
+	}
+
+	return info
+}
+
+// Auxiliary function to print the details found on a port below it
+func printDetails(port utils.Port) {
+	for _, detail := range port.Details() {
+		fmt.Printf("    %s\n", detail)
+	}
+}
+
+// TLS worker for go routine multithreading
+func tlsWorker(target string, port utils.Port, timeout time.Duration, enumerate bool, results chan<- utils.Port, wg *sync.WaitGroup) {
+	// Ensure worker is done
+	defer wg.Done()
+
+	port.TLS = InspectTLS(net.JoinHostPort(target, strconv.Itoa(port.Port)), timeout, enumerate)
+	results <- port
+}
+
+// Function to inspect the TLS handshake of every open TCP port not inspected yet
+func InspectPorts(scan utils.ScanParameters, ports []utils.Port) []utils.Port {
+	var results []utils.Port
+	resultChan := make(chan utils.Port, len(ports))
+	var wg sync.WaitGroup
+
+	fmt.Printf("%s[*] Starting TLS inspection on host %s%s\n", utils.Blue, scan.Target, utils.Reset)
+	fmt.Println(utils.Lines)
+
+	for _, port := range ports {
+		// Ports already found speaking TLS by version detection are not handshaked twice
+		if port.Status != "open" || port.TLS != nil {
+			resultChan <- port
+			continue
+		}
+
+		wg.Add(1)
+		go tlsWorker(scan.Target, port, scan.Timeout, scan.TLSVersions, resultChan, &wg)
+	}
+
+	wg.Wait()
+	close(resultChan)
+
+	found := 0
+
+	for result := range resultChan {
+		results = append(results, result)
+
+		if result.TLS == nil {
+			continue
+		}
+
+		found++
+		utils.PrintSuccess(fmt.Sprintf("[+] %d/tcp %s", result.Port, result.Service))
+		printDetails(result)
+	}
+
+	fmt.Println(utils.Lines)
+	fmt.Printf("%s[*] TLS inspection finished on host %s%s\n", utils.Blue, scan.Target, utils.Reset)
+	fmt.Printf("%s[*] %d ports speaking TLS %s\n", utils.Blue, found, utils.Reset)
+
+	return results
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"gmap/utils"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version detection parameters
+const (
+	// Time to wait for the first bytes of a response
+	probeReadTimeout = 2 * time.Second
+	// Time to wait for the rest of a response once it started arriving
+	probeTrailTimeout = 300 * time.Millisecond
+	maxResponseSize   = 16 * 1024
+)
+
+// Result of matching a response against the database
+type serviceVersion struct {
+	service string
+	product string
+	version string
+	info    string
+	soft    bool
+}
+
+// Auxiliary function to send a probe and read the response, the connection is wrapped in TLS when asked
+func sendProbe(address string, payload []byte, wrapTLS bool, timeout time.Duration, wait time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if wrapTLS {
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		tlsConn.SetDeadline(time.Now().Add(timeout + probeReadTimeout))
+
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	defer conn.Close()
+
+	if len(payload) > 0 {
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	response := make([]byte, 0, maxResponseSize)
+	buffer := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(wait))
+
+	// Keep reading until the service stops sending
+	for len(response) < maxResponseSize {
+		n, err := conn.Read(buffer)
+		response = append(response, buffer[:n]...)
+
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, io.EOF) || len(response) > 0 {
+				break
+			}
+			return nil, err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(probeTrailTimeout))
+	}
+
+	return response, nil
+}
+
+// Auxiliary function to match a response against the signatures of a probe, hard matches win over soft ones
+func (p *serviceProbe) match(response string) (serviceVersion, bool) {
+	var soft *serviceVersion
+
+	for _, match := range p.matches {
+		groups := match.pattern.FindStringSubmatch(response)
+		if groups == nil {
+			continue
+		}
+
+		if match.soft {
+			if soft == nil {
+				soft = &serviceVersion{service: match.service, soft: true}
+			}
+			continue
+		}
+
+		return serviceVersion{
+			service: match.service,
+			product: fillTemplate(match.product, groups),
+			version: fillTemplate(match.version, groups),
+			info:    fillTemplate(match.info, groups),
+		}, true
+	}
+
+	if soft != nil {
+		return *soft, true
+	}
+
+	return serviceVersion{}, false
+}
+
+// Auxiliary function to match a response to a probe against its signatures, then its fallbacks and then the NULL probe
+func (d *probeDatabase) matchResponse(sent *serviceProbe, response []byte) (serviceVersion, bool) {
+	decoded := latin1(response)
+	candidates := append([]*serviceProbe{sent}, sent.fallbacks...)
+
+	// Banners sent right after connecting show up in the response to any probe
+	for _, probe := range d.probes {
+		if probe.protocol == sent.protocol && len(probe.payload) == 0 && probe != sent {
+			candidates = append(candidates, probe)
+		}
+	}
+
+	var soft *serviceVersion
+
+	for _, probe := range candidates {
+		version, ok := probe.match(decoded)
+		if !ok {
+			continue
+		}
+
+		if !version.soft {
+			return version, true
+		}
+
+		if soft == nil {
+			soft = &version
+		}
+	}
+
+	if soft != nil {
+		return *soft, true
+	}
+
+	return serviceVersion{}, false
+}
+
+// Auxiliary function to run the probes against a port until one response matches
+func (d *probeDatabase) probeService(address string, port int, intensity int, wrapTLS bool, timeout time.Duration) (serviceVersion, bool) {
+	var soft *serviceVersion
+
+	for _, probe := range d.probesFor(port, intensity, wrapTLS) {
+		// Once the service is known only probes able to tell its version are worth sending
+		if soft != nil && !probe.canMatch(soft.service) {
+			continue
+		}
+
+		wait := probe.wait
+		if wait == 0 {
+			wait = probeReadTimeout
+		}
+
+		response, err := sendProbe(address, probe.payload, wrapTLS, timeout, wait)
+		if err != nil {
+			// A port that cannot be reached anymore will not answer the other probes
+			var opErr *net.OpError
+			if errors.As(err, &opErr) && opErr.Op == "dial" {
+				break
+			}
+			continue
+		}
+
+		if len(response) == 0 {
+			continue
+		}
+
+		version, ok := d.matchResponse(probe, response)
+		if !ok {
+			continue
+		}
+
+		if !version.soft {
+			return version, true
+		}
+
+		if soft == nil {
+			soft = &version
+		}
+	}
+
+	if soft != nil {
+		return *soft, true
+	}
+
+	return serviceVersion{}, false
+}
+
+// Version worker for go routine multithreading
+func versionWorker(scan utils.ScanParameters, port utils.Port, database *probeDatabase, results chan<- utils.Port, wg *sync.WaitGroup) {
+	// Ensure worker is done
+	defer wg.Done()
+
+	address := net.JoinHostPort(scan.Target, strconv.Itoa(port.Port))
+	version, ok := database.probeService(address, port.Port, scan.VersionIntensity, false, scan.Timeout)
+
+	// Look for the service behind TLS and inspect the handshake on the way
+	if ok && version.service == "ssl" {
+		port.TLS = InspectTLS(address, scan.Timeout, scan.TLSVersions)
+
+		if inner, ok := database.probeService(address, port.Port, scan.VersionIntensity, true, scan.Timeout); ok {
+			version = inner
+			version.service = "ssl/" + inner.service
+		}
+	}
+
+	if ok {
+		port.Service = version.service
+		port.Product = version.product
+		port.Version = version.version
+		port.ExtraInfo = version.info
+	}
+
+	results <- port
+}
+
+// Function to detect the service, product and version running on open TCP ports
+func DetectVersions(scan utils.ScanParameters, ports []utils.Port) []utils.Port {
+	var results []utils.Port
+	resultChan := make(chan utils.Port, len(ports))
+	var wg sync.WaitGroup
+
+	fmt.Printf("%s[*] Starting version detection on host %s (intensity %d)%s\n", utils.Blue, scan.Target, scan.VersionIntensity, utils.Reset)
+	fmt.Println(utils.Lines)
+
+	database, err := loadServiceProbes()
+	if err != nil {
+		fmt.Println(utils.PrintError(fmt.Sprintf("[ERROR] Could not load service probes: %v", err)))
+		return ports
+	}
+
+	for _, port := range ports {
+		// Only open ports answer probes, and some services misbehave when probed (e.g. printers)
+		if port.Status != "open" || database.excluded[port.Port] {
+			resultChan <- port
+			continue
+		}
+
+		wg.Add(1)
+		go versionWorker(scan, port, database, resultChan, &wg)
+	}
+
+	wg.Wait()
+	close(resultChan)
+
+	identified := 0
+
+	for result := range resultChan {
+		results = append(results, result)
+
+		if result.Status != "open" {
+			continue
+		}
+
+		if result.Product != "" || result.Version != "" {
+			identified++
+		}
+
+		details := strings.TrimSpace(fmt.Sprintf("%s %s", result.Product, result.Version))
+		if result.ExtraInfo != "" {
+			details += fmt.Sprintf(" (%s)", result.ExtraInfo)
+		}
+
+		utils.PrintSuccess(strings.TrimSpace(fmt.Sprintf("[+] %d/tcp %s %s", result.Port, result.Service, details)))
+		printDetails(result)
+	}
+
+	fmt.Println(utils.Lines)
+	fmt.Printf("%s[*] Version detection finished on host %s%s\n", utils.Blue, scan.Target, utils.Reset)
+	fmt.Printf("%s[*] %d versions identified %s\n", utils.Blue, identified, utils.Reset)
+
+	return results
+}
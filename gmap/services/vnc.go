@@ -0,0 +1,135 @@
+package services
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gmap/utils"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Security type letting clients in without authentication
+const vncSecurityNone = 1
+
+// Protocol version sent by RFB servers, e.g., RFB 003.008
+var rfbVersion = regexp.MustCompile(`^RFB (\d{3})\.(\d{3})\n$`)
+
+// Names of the registered RFB security types
+var vncSecurityTypes = map[byte]string{
+	1:   "None",
+	2:   "VNC Authentication",
+	5:   "RA2",
+	6:   "RA2ne",
+	16:  "Tight",
+	17:  "Ultra",
+	18:  "TLS",
+	19:  "VeNCrypt",
+	20:  "SASL",
+	21:  "MD5",
+	22:  "xvp",
+	30:  "Apple Remote Desktop",
+	113: "UltraVNC MS-Logon II",
+	129: "TightVNC Unix Login",
+}
+
+// Auxiliary function to read the reason sent by a server refusing the connection
+func readRFBReason(conn net.Conn) string {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return ""
+	}
+
+	reason := make([]byte, min(binary.BigEndian.Uint32(length), maxResponseSize))
+	if _, err := io.ReadFull(conn, reason); err != nil {
+		return ""
+	}
+
+	return string(reason)
+}
+
+// Auxiliary function to read the security types offered, as a list since RFB 3.7 and chosen by the server before
+func readSecurityTypes(conn net.Conn, minor int) ([]byte, error) {
+	if minor < 7 {
+		chosen := make([]byte, 4)
+		if _, err := io.ReadFull(conn, chosen); err != nil {
+			return nil, err
+		}
+
+		if binary.BigEndian.Uint32(chosen) == 0 {
+			return nil, errors.New(readRFBReason(conn))
+		}
+		return []byte{byte(binary.BigEndian.Uint32(chosen))}, nil
+	}
+
+	count := make([]byte, 1)
+	if _, err := io.ReadFull(conn, count); err != nil {
+		return nil, err
+	}
+
+	if count[0] == 0 {
+		return nil, errors.New(readRFBReason(conn))
+	}
+
+	types := make([]byte, count[0])
+	_, err := io.ReadFull(conn, types)
+
+	return types, err
+}
+
+// Read the RFB version of a VNC server and the security types it offers, flagging servers without authentication
+func enumerateVNC(address string, port *utils.Port, scan utils.ScanParameters) {
+	conn, err := net.DialTimeout("tcp", address, scan.Timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(scan.Timeout + probeReadTimeout))
+
+	version := make([]byte, 12)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		return
+	}
+
+	match := rfbVersion.FindSubmatch(version)
+	if match == nil {
+		return
+	}
+
+	major, _ := strconv.Atoi(string(match[1]))
+	minor, _ := strconv.Atoi(string(match[2]))
+
+	info := &utils.VNCInfo{ProtocolVersion: fmt.Sprintf("%d.%d", major, minor)}
+	port.VNC = info
+
+	// Versions after 3.8 are answered with 3.8, the highest standard one, and unknown ones with 3.3
+	switch {
+	case major > 3 || minor >= 8:
+		minor = 8
+	case minor != 7:
+		minor = 3
+	}
+
+	if _, err := conn.Write([]byte(fmt.Sprintf("RFB 003.%03d\n", minor))); err != nil {
+		return
+	}
+
+	types, err := readSecurityTypes(conn, minor)
+	if err != nil {
+		info.Error = err.Error()
+		return
+	}
+
+	for _, kind := range types {
+		name, ok := vncSecurityTypes[kind]
+		if !ok {
+			name = fmt.Sprintf("Unknown %d", kind)
+		}
+
+		info.SecurityTypes = append(info.SecurityTypes, name)
+		info.NoAuth = info.NoAuth || kind == vncSecurityNone
+	}
+}
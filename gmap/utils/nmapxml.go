@@ -0,0 +1,444 @@
+package utils
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the nmap XML format written
+const nmapXMLOutputVersion = "1.05"
+
+// Elements of the nmap XML output, in the order the nmap DTD expects them
+type nmapRun struct {
+	XMLName          xml.Name       `xml:"nmaprun"`
+	Scanner          string         `xml:"scanner,attr"`
+	Args             string         `xml:"args,attr,omitempty"`
+	Start            int64          `xml:"start,attr"`
+	StartStr         string         `xml:"startstr,attr"`
+	Version          string         `xml:"version,attr"`
+	XMLOutputVersion string         `xml:"xmloutputversion,attr"`
+	ScanInfo         []nmapScanInfo `xml:"scaninfo"`
+	Verbose          nmapLevel      `xml:"verbose"`
+	Debugging        nmapLevel      `xml:"debugging"`
+	Hosts            []nmapHost     `xml:"host"`
+	RunStats         nmapRunStats   `xml:"runstats"`
+}
+
+type nmapScanInfo struct {
+	Type        string `xml:"type,attr"`
+	Protocol    string `xml:"protocol,attr"`
+	NumServices int    `xml:"numservices,attr"`
+	Services    string `xml:"services,attr"`
+}
+
+type nmapLevel struct {
+	Level int `xml:"level,attr"`
+}
+
+type nmapHost struct {
+	Status    nmapStatus    `xml:"status"`
+	Addresses []nmapAddress `xml:"address"`
+	Hostnames struct{}      `xml:"hostnames"`
+	Ports     *nmapPorts    `xml:"ports,omitempty"`
+	OS        *nmapOS       `xml:"os,omitempty"`
+	Distance  *nmapDistance `xml:"distance,omitempty"`
+	Trace     *nmapTrace    `xml:"trace,omitempty"`
+	Times     *nmapTimes    `xml:"times,omitempty"`
+}
+
+type nmapStatus struct {
+	State     string `xml:"state,attr"`
+	Reason    string `xml:"reason,attr"`
+	ReasonTTL string `xml:"reason_ttl,attr"`
+}
+
+type nmapAddress struct {
+	Addr     string `xml:"addr,attr"`
+	AddrType string `xml:"addrtype,attr"`
+	Vendor   string `xml:"vendor,attr,omitempty"`
+}
+
+type nmapPorts struct {
+	Ports []nmapPort `xml:"port"`
+}
+
+type nmapPort struct {
+	Protocol string       `xml:"protocol,attr"`
+	PortID   int          `xml:"portid,attr"`
+	State    nmapState    `xml:"state"`
+	Service  *nmapService `xml:"service,omitempty"`
+	Scripts  []nmapScript `xml:"script"`
+}
+
+type nmapState struct {
+	State     string `xml:"state,attr"`
+	Reason    string `xml:"reason,attr"`
+	ReasonTTL string `xml:"reason_ttl,attr"`
+}
+
+type nmapService struct {
+	Name      string `xml:"name,attr"`
+	Product   string `xml:"product,attr,omitempty"`
+	Version   string `xml:"version,attr,omitempty"`
+	ExtraInfo string `xml:"extrainfo,attr,omitempty"`
+	Tunnel    string `xml:"tunnel,attr,omitempty"`
+	Method    string `xml:"method,attr"`
+	Conf      int    `xml:"conf,attr"`
+}
+
+type nmapScript struct {
+	ID     string `xml:"id,attr"`
+	Output string `xml:"output,attr"`
+}
+
+type nmapOS struct {
+	Matches []nmapOSMatch `xml:"osmatch"`
+}
+
+type nmapOSMatch struct {
+	Name     string        `xml:"name,attr"`
+	Accuracy int           `xml:"accuracy,attr"`
+	Line     int           `xml:"line,attr"`
+	Classes  []nmapOSClass `xml:"osclass"`
+}
+
+type nmapOSClass struct {
+	Vendor   string   `xml:"vendor,attr"`
+	OSFamily string   `xml:"osfamily,attr"`
+	Accuracy int      `xml:"accuracy,attr"`
+	CPE      []string `xml:"cpe"`
+}
+
+type nmapDistance struct {
+	Value int `xml:"value,attr"`
+}
+
+type nmapTrace struct {
+	Hops []nmapHop `xml:"hop"`
+}
+
+type nmapHop struct {
+	TTL    int    `xml:"ttl,attr"`
+	IPAddr string `xml:"ipaddr,attr,omitempty"`
+	RTT    string `xml:"rtt,attr,omitempty"`
+	Host   string `xml:"host,attr,omitempty"`
+}
+
+type nmapTimes struct {
+	SRTT   int64 `xml:"srtt,attr"`
+	RTTVar int64 `xml:"rttvar,attr"`
+	To     int64 `xml:"to,attr"`
+}
+
+type nmapRunStats struct {
+	Finished nmapFinished  `xml:"finished"`
+	Hosts    nmapHostStats `xml:"hosts"`
+}
+
+type nmapFinished struct {
+	Time    int64  `xml:"time,attr"`
+	TimeStr string `xml:"timestr,attr"`
+	Elapsed string `xml:"elapsed,attr"`
+	Summary string `xml:"summary,attr"`
+	Exit    string `xml:"exit,attr"`
+}
+
+type nmapHostStats struct {
+	Up    int `xml:"up,attr"`
+	Down  int `xml:"down,attr"`
+	Total int `xml:"total,attr"`
+}
+
+// Scan types and the nmap name and protocol of each, idle scans have no scaninfo as the nmap DTD has no type for them
+var nmapScanTypes = map[string][2]string{
+	"tcp":         {"connect", "tcp"},
+	"syn":         {"syn", "tcp"},
+	"udp":         {"udp", "udp"},
+	"sctp-init":   {"sctpinit", "sctp"},
+	"sctp-cookie": {"sctpcookieecho", "sctp"},
+	"proto":       {"ipproto", "ip"},
+}
+
+// Reasons nmap gives for a host being up, by discovery method
+var nmapHostReasons = map[string]string{
+	"user-set":       "user-set",
+	"arp":            "arp-response",
+	"icmp-echo":      "echo-reply",
+	"icmp-timestamp": "timestamp-reply",
+	"icmp-mask":      "mask-reply",
+	"tcp-syn":        "syn-ack",
+	"tcp-ack":        "reset",
+	"tcp-connect":    "syn-ack",
+	"udp":            "udp-response",
+}
+
+// Tables of the services named by the port or protocol number, by protocol
+var nmapServiceTables = map[string]map[int]string{
+	"tcp":  CommonServices,
+	"udp":  CommonServices,
+	"sctp": CommonSctpServices,
+	"ip":   IPProtocols,
+}
+
+// nmap-services names of the well known ports named after the service usually found on them, unknown when nmap has none
+var nmapPortServices = map[int]string{
+	2082:  "infowave",
+	2083:  "radsec",
+	4444:  "krb524",
+	8086:  "d-s-n",
+	9000:  "cslistener",
+	9092:  "XmlIpcRegSvc",
+	9200:  "wap-wsp",
+	9300:  "vrace",
+	50000: "ibm-db2",
+	50070: "unknown",
+}
+
+// Auxiliary function to check if a service name is one of the nmap-services (or nmap-protocols) names of a protocol
+func nmapServiceKnown(protocol string, name string) bool {
+	for _, known := range nmapServiceTables[protocol] {
+		if known == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Auxiliary function to write a list of ports as nmap does, with consecutive ports as ranges
+func nmapServices(ports []int) string {
+	sorted := append([]int(nil), ports...)
+	sort.Ints(sorted)
+
+	var ranges []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+
+		if sorted[i] == sorted[j] {
+			ranges = append(ranges, strconv.Itoa(sorted[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+
+	return strings.Join(ranges, ",")
+}
+
+// Auxiliary function to get the reason nmap gives for the state of a port
+func nmapPortReason(scanType string, state string) string {
+	switch state {
+	case "open":
+		switch scanType {
+		case "udp":
+			return "udp-response"
+		case "sctp-init", "sctp-cookie":
+			return "init-ack"
+		case "proto":
+			return "proto-response"
+		}
+		return "syn-ack"
+	case "closed":
+		switch scanType {
+		case "tcp":
+			return "conn-refused"
+		case "udp":
+			return "port-unreach"
+		case "sctp-init", "sctp-cookie":
+			return "abort"
+		case "proto":
+			return "proto-unreach"
+		}
+		return "reset"
+	}
+
+	return "no-response"
+}
+
+// Auxiliary function to describe a port as nmap does, with its service and the banner and enumeration findings as scripts
+func nmapPortOf(port Port, scanType string) nmapPort {
+	protocol := "tcp"
+	if kind, ok := nmapScanTypes[scanType]; ok {
+		protocol = kind[1]
+	}
+
+	result := nmapPort{
+		Protocol: protocol,
+		PortID:   port.Port,
+		// nmap writes ambiguous states with a pipe
+		State: nmapState{State: strings.ReplaceAll(port.Status, "/", "|"), Reason: nmapPortReason(scanType, port.Status), ReasonTTL: "0"},
+	}
+
+	name := strings.TrimPrefix(port.Service, "ssl/")
+	service := &nmapService{Name: name, Product: port.Product, Version: port.Version, ExtraInfo: port.ExtraInfo, Method: "table", Conf: 3}
+
+	// Names found by version detection are kept, the others have to be in the table of the protocol
+	if port.Product != "" || strings.HasPrefix(port.Service, "ssl/") {
+		service.Method = "probed"
+		service.Conf = 10
+	} else if nmapName, ok := nmapPortServices[port.Port]; ok && protocol != "sctp" && protocol != "ip" && name == CommonServices[port.Port] {
+		service.Name = nmapName
+	} else if !nmapServiceKnown(protocol, name) {
+		service.Name = "unknown"
+	}
+
+	if port.TLS != nil || strings.HasPrefix(port.Service, "ssl/") {
+		service.Tunnel = "ssl"
+	}
+	result.Service = service
+
+	if port.Banner != nil {
+		result.Scripts = append(result.Scripts, nmapScript{ID: "banner", Output: port.Banner.Text})
+	}
+
+	if details := port.Details(); len(details) > 0 {
+		result.Scripts = append(result.Scripts, nmapScript{ID: "gmap-enum", Output: strings.Join(details, "\n")})
+	}
+
+	return result
+}
+
+// Auxiliary function to describe a host as nmap does
+func nmapHostOf(host Host, run ScanRun) nmapHost {
+	result := nmapHost{Status: nmapStatus{State: host.Status, Reason: "no-response", ReasonTTL: "0"}}
+
+	if host.Status == "up" {
+		method, _, _ := strings.Cut(host.Method, "/")
+		if reason, ok := nmapHostReasons[method]; ok {
+			result.Status.Reason = reason
+		} else {
+			result.Status.Reason = "unknown-response"
+		}
+	}
+
+	addrType := "ipv6"
+	if ip := net.ParseIP(host.Address); ip == nil || ip.To4() != nil {
+		addrType = "ipv4"
+	}
+	result.Addresses = append(result.Addresses, nmapAddress{Addr: host.Address, AddrType: addrType})
+
+	if host.MAC != "" {
+		result.Addresses = append(result.Addresses, nmapAddress{Addr: strings.ToUpper(host.MAC), AddrType: "mac", Vendor: host.Vendor})
+	}
+
+	if run.ScanType != "" && host.Status == "up" {
+		result.Ports = &nmapPorts{}
+		for _, port := range host.Ports {
+			result.Ports.Ports = append(result.Ports.Ports, nmapPortOf(port, run.ScanType))
+		}
+	}
+
+	if len(host.OSMatches) > 0 {
+		result.OS = &nmapOS{}
+		for _, match := range host.OSMatches {
+			osMatch := nmapOSMatch{Name: match.Name, Accuracy: match.Accuracy}
+
+			// CPEs name the vendor and product of the OS, e.g., cpe:/o:linux:linux_kernel:5
+			for _, cpe := range match.CPE {
+				fields := strings.Split(strings.TrimPrefix(cpe, "cpe:/"), ":")
+				if len(fields) < 3 {
+					continue
+				}
+				osMatch.Classes = append(osMatch.Classes, nmapOSClass{Vendor: fields[1], OSFamily: fields[2], Accuracy: match.Accuracy, CPE: []string{cpe}})
+			}
+
+			result.OS.Matches = append(result.OS.Matches, osMatch)
+		}
+	}
+
+	if len(host.Hops) > 0 {
+		result.Distance = &nmapDistance{Value: host.Hops[len(host.Hops)-1].TTL}
+		result.Trace = &nmapTrace{}
+		for _, hop := range host.Hops {
+			rtt := fmt.Sprintf("%.2f", float64(hop.RTT.Microseconds())/1000)
+			result.Trace.Hops = append(result.Trace.Hops, nmapHop{TTL: hop.TTL, IPAddr: hop.Address, RTT: rtt, Host: hop.Hostname})
+		}
+	}
+
+	// Times are in microseconds, the latency of discovery is the only round trip measured
+	if host.Latency > 0 {
+		srtt := host.Latency.Microseconds()
+		result.Times = &nmapTimes{SRTT: srtt, RTTVar: srtt, To: max(srtt*5, 100000)}
+	}
+
+	return result
+}
+
+func exportToXml(hosts []Host, run ScanRun, file *os.File) error {
+	result := nmapRun{
+		// The nmap DTD only allows nmap as the scanner
+		Scanner:          "nmap",
+		Args:             run.Args,
+		Start:            run.Start.Unix(),
+		StartStr:         run.Start.Format(time.ANSIC),
+		Version:          "gmap",
+		XMLOutputVersion: nmapXMLOutputVersion,
+	}
+
+	if kind, ok := nmapScanTypes[run.ScanType]; ok {
+		result.ScanInfo = append(result.ScanInfo, nmapScanInfo{Type: kind[0], Protocol: kind[1], NumServices: len(run.Ports), Services: nmapServices(run.Ports)})
+	}
+
+	// Targets missing from the results were not found up by a ping sweep
+	if len(run.Targets) > 0 {
+		found := make(map[string]Host)
+		for _, host := range hosts {
+			found[host.Address] = host
+		}
+
+		hosts = nil
+		for _, target := range run.Targets {
+			host, ok := found[target]
+			if !ok {
+				host = Host{Address: target, Status: "down"}
+			}
+			hosts = append(hosts, host)
+		}
+	}
+
+	up := 0
+	for _, host := range hosts {
+		if host.Status == "up" {
+			up++
+		}
+		result.Hosts = append(result.Hosts, nmapHostOf(host, run))
+	}
+
+	elapsed := run.End.Sub(run.Start).Seconds()
+	result.RunStats = nmapRunStats{
+		Finished: nmapFinished{
+			Time:    run.End.Unix(),
+			TimeStr: run.End.Format(time.ANSIC),
+			Elapsed: fmt.Sprintf("%.2f", elapsed),
+			Summary: fmt.Sprintf("gmap done at %s; %d IP addresses (%d hosts up) scanned in %.2f seconds", run.End.Format(time.ANSIC), len(hosts), up, elapsed),
+			Exit:    "success",
+		},
+		Hosts: nmapHostStats{Up: up, Down: len(hosts) - up, Total: len(hosts)},
+	}
+
+	if _, err := file.WriteString(xml.Header + "<!DOCTYPE nmaprun>\n"); err != nil {
+		return PrintError(fmt.Sprintf("[ERROR] could not write to file: %v", err))
+	}
+
+	encoder := xml.NewEncoder(file)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		return PrintError(fmt.Sprintf("[ERROR] could not encode results to XML: %v", err))
+	}
+
+	if _, err := file.WriteString("\n"); err != nil {
+		return PrintError(fmt.Sprintf("[ERROR] could not write to file: %v", err))
+	}
+
+	PrintSuccess("[!] Results successfully exported to .xml file")
+	return nil
+}
@@ -0,0 +1,235 @@
+package utils
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Attributes the nmap DTD requires on each element written
+var nmapRequiredAttributes = map[string][]string{
+	"nmaprun":   {"scanner", "version", "xmloutputversion"},
+	"scaninfo":  {"type", "protocol", "numservices", "services"},
+	"verbose":   {"level"},
+	"debugging": {"level"},
+	"status":    {"state", "reason", "reason_ttl"},
+	"address":   {"addr", "addrtype"},
+	"port":      {"protocol", "portid"},
+	"state":     {"state", "reason", "reason_ttl"},
+	"service":   {"name", "method", "conf"},
+	"script":    {"id", "output"},
+	"osmatch":   {"name", "accuracy", "line"},
+	"osclass":   {"vendor", "osfamily", "accuracy"},
+	"distance":  {"value"},
+	"hop":       {"ttl"},
+	"times":     {"srtt", "rttvar", "to"},
+	"finished":  {"time"},
+	"hosts":     {"up", "down", "total"},
+}
+
+// Scan types listed by the nmap DTD
+var nmapDTDScanTypes = []string{"syn", "ack", "bounce", "connect", "null", "xmas", "window", "maimon", "fin", "udp", "sctpinit", "sctpcookieecho", "ipproto"}
+
+// Element of the exported document with its attributes and the names of its children in order
+type xmlElement struct {
+	name       string
+	attributes map[string]string
+	children   []string
+}
+
+// Auxiliary function to export hosts to XML and read every element back, in document order
+func exportAndParse(t *testing.T, hosts []Host, run ScanRun) []*xmlElement {
+	t.Helper()
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "results.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := exportToXml(hosts, run, file); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	var elements, open []*xmlElement
+	decoder := xml.NewDecoder(file)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid XML: %v", err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			element := &xmlElement{name: token.Name.Local, attributes: make(map[string]string)}
+			for _, attribute := range token.Attr {
+				element.attributes[attribute.Name.Local] = attribute.Value
+			}
+
+			if len(open) > 0 {
+				parent := open[len(open)-1]
+				parent.children = append(parent.children, element.name)
+			}
+			elements = append(elements, element)
+			open = append(open, element)
+		case xml.EndElement:
+			open = open[:len(open)-1]
+		}
+	}
+
+	return elements
+}
+
+// Auxiliary function to get the elements with a name
+func elementsNamed(elements []*xmlElement, name string) []*xmlElement {
+	var named []*xmlElement
+	for _, element := range elements {
+		if element.name == name {
+			named = append(named, element)
+		}
+	}
+
+	return named
+}
+
+func TestExportToXmlHost(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	host := Host{
+		Address: "192.168.1.10",
+		Status:  "up",
+		Method:  "arp",
+		Latency: 2 * time.Millisecond,
+		MAC:     "00:0c:29:aa:bb:cc",
+		Vendor:  "VMware",
+		OSMatches: []OSMatch{
+			{Name: "Linux 5.0 - 5.14", Accuracy: 95, CPE: []string{"cpe:/o:linux:linux_kernel:5"}},
+		},
+		Hops: []Hop{
+			{TTL: 1, Address: "192.168.1.1", RTT: time.Millisecond},
+			{TTL: 2, Address: "192.168.1.10", RTT: 2 * time.Millisecond, Hostname: "target.lan"},
+		},
+		Ports: []Port{
+			{Port: 21, Status: "open", Service: "ftp", Banner: NewBanner([]byte("220 FTP ready\r\n"))},
+			{Port: 443, Status: "open", Service: "ssl/http", Product: "nginx", Version: "1.24.0"},
+			{Port: 9999, Status: "closed", Service: "unknown"},
+			{Port: 9092, Status: "open", Service: CommonServices[9092]},
+			{Port: 50070, Status: "open", Service: CommonServices[50070]},
+			{Port: 9200, Status: "open", Service: "http", Product: "Elasticsearch REST API"},
+		},
+	}
+	run := ScanRun{Args: "gmap -t 192.168.1.10", ScanType: "syn", Ports: []int{21, 443, 9092, 9200, 9999, 50070}, Targets: []string{host.Address}, Start: start, End: start.Add(5 * time.Second)}
+
+	elements := exportAndParse(t, []Host{host}, run)
+
+	for _, element := range elements {
+		for _, attribute := range nmapRequiredAttributes[element.name] {
+			if _, ok := element.attributes[attribute]; !ok {
+				t.Errorf("<%s> is missing the %s attribute", element.name, attribute)
+			}
+		}
+	}
+
+	orders := map[string][]string{
+		"nmaprun":  {"scaninfo", "verbose", "debugging", "host", "runstats"},
+		"host":     {"status", "address", "address", "hostnames", "ports", "os", "distance", "trace", "times"},
+		"ports":    {"port", "port", "port", "port", "port", "port"},
+		"port":     {"state", "service", "script"},
+		"os":       {"osmatch"},
+		"osmatch":  {"osclass"},
+		"trace":    {"hop", "hop"},
+		"runstats": {"finished", "hosts"},
+	}
+	for name, children := range orders {
+		if element := elementsNamed(elements, name)[0]; !reflect.DeepEqual(element.children, children) {
+			t.Errorf("<%s> children are %v, want %v", name, element.children, children)
+		}
+	}
+
+	addresses := elementsNamed(elements, "address")
+	if addresses[1].attributes["addrtype"] != "mac" || addresses[1].attributes["addr"] != "00:0C:29:AA:BB:CC" || addresses[1].attributes["vendor"] != "VMware" {
+		t.Errorf("MAC address is %v", addresses[1].attributes)
+	}
+
+	services := elementsNamed(elements, "service")
+	wants := []map[string]string{
+		{"name": "ftp", "method": "table"},
+		{"name": "http", "method": "probed", "tunnel": "ssl", "product": "nginx"},
+		{"name": "unknown", "method": "table"},
+		{"name": "XmlIpcRegSvc", "method": "table"},
+		{"name": "unknown", "method": "table"},
+		{"name": "http", "method": "probed", "product": "Elasticsearch REST API"},
+	}
+	for i, want := range wants {
+		for attribute, value := range want {
+			if services[i].attributes[attribute] != value {
+				t.Errorf("service %d has %s=%q, want %q", i, attribute, services[i].attributes[attribute], value)
+			}
+		}
+	}
+
+	if states := elementsNamed(elements, "state"); states[2].attributes["reason"] != "reset" {
+		t.Errorf("closed port reason is %q, want reset", states[2].attributes["reason"])
+	}
+
+	if distance := elementsNamed(elements, "distance")[0]; distance.attributes["value"] != "2" {
+		t.Errorf("distance is %q, want 2", distance.attributes["value"])
+	}
+}
+
+func TestExportToXmlPingSweep(t *testing.T) {
+	start := time.Now()
+	hosts := []Host{{Address: "10.0.0.2", Status: "up", Method: "icmp-echo"}}
+	run := ScanRun{Args: "gmap -sn -t 10.0.0.1-3", Targets: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, Start: start, End: start.Add(time.Second)}
+
+	elements := exportAndParse(t, hosts, run)
+
+	if scanInfo := elementsNamed(elements, "scaninfo"); len(scanInfo) != 0 {
+		t.Errorf("ping sweeps have no scaninfo, got %d", len(scanInfo))
+	}
+
+	var states []string
+	for _, status := range elementsNamed(elements, "status") {
+		states = append(states, status.attributes["state"])
+	}
+	if want := []string{"down", "up", "down"}; !reflect.DeepEqual(states, want) {
+		t.Errorf("host states are %v, want %v", states, want)
+	}
+
+	stats := elementsNamed(elements, "hosts")[0].attributes
+	if stats["up"] != "1" || stats["down"] != "2" || stats["total"] != "3" {
+		t.Errorf("host stats are %v, want 1 up, 2 down, 3 total", stats)
+	}
+
+	if ports := elementsNamed(elements, "ports"); len(ports) != 0 {
+		t.Errorf("ping sweeps have no ports, got %d", len(ports))
+	}
+
+	if summary := elementsNamed(elements, "finished")[0].attributes["summary"]; !strings.Contains(summary, "3 IP addresses (1 hosts up)") {
+		t.Errorf("summary is %q", summary)
+	}
+}
+
+func TestNmapScanTypesInDTD(t *testing.T) {
+	for scanType, kind := range nmapScanTypes {
+		found := false
+		for _, known := range nmapDTDScanTypes {
+			found = found || kind[0] == known
+		}
+
+		if !found {
+			t.Errorf("scan type %s is written as %s, not a scan type of the nmap DTD", scanType, kind[0])
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package utils
 
 import (
+	"encoding/base64"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -30,81 +32,151 @@ const (
 	BrightWhite  = "\033[97m"
 	Lines        = "--------------------------------"
 	CommonPorts  = "20,21,22,23,25,53,67,68,69,80,110,119,123,135,137,138,139,143,161,162,179,194,443,445,465,587,993,995,1433,3306"
+	// Ports scanned by default on SCTP scans
+	CommonSctpPorts = "80,443,2904,2905,2944,3565,3868,3863,4739,5060,5061,7626,9899,9900,14001,29118,29168,36412,36422,38412,38422"
+	// Protocols scanned by default on IP protocol scans
+	AllProtocols = "0-255"
 )
 
-// Common Services
+// Common Services, named as in nmap-services unless the service usually found on the port has another name
 var CommonServices = map[int]string{
-	20:    "FTP Data",
-	21:    "FTP Control",
-	22:    "SSH",
-	23:    "Telnet",
-	25:    "SMTP",
-	53:    "DNS",
-	67:    "DHCP Server",
-	68:    "DHCP Client",
-	69:    "TFTP",
-	80:    "HTTP",
-	110:   "POP3",
-	119:   "NNTP",
-	123:   "NTP",
-	135:   "MS RPC",
-	137:   "NetBIOS Name Service",
-	138:   "NetBIOS Datagram Service",
-	139:   "NetBIOS Session Service",
-	143:   "IMAP",
-	161:   "SNMP",
-	162:   "SNMP Trap",
-	179:   "BGP",
-	194:   "IRC",
-	443:   "HTTPS",
-	445:   "Microsoft-DS",
-	465:   "SMTPS",
-	514:   "Syslog",
-	515:   "LPD",
-	587:   "Submission",
-	631:   "IPP",
-	636:   "LDAPS",
-	993:   "IMAPS",
-	995:   "POP3S",
-	1080:  "SOCKS",
-	1194:  "OpenVPN",
-	1433:  "MSSQL",
-	1434:  "MSSQL Monitor",
-	1521:  "Oracle",
-	1723:  "PPTP",
-	1883:  "MQTT",
-	1900:  "SSDP",
-	2049:  "NFS",
-	2082:  "cPanel",
-	2083:  "cPanel Secure",
-	2483:  "Oracle DB Secure",
-	2484:  "Oracle DB",
-	3306:  "MySQL",
-	3389:  "RDP",
-	3690:  "Subversion",
-	4444:  "Metasploit",
-	4848:  "GlassFish Admin",
-	5432:  "PostgreSQL",
-	5632:  "pcAnywhere",
-	5900:  "VNC",
-	5984:  "CouchDB",
-	6379:  "Redis",
-	8000:  "Common HTTP Alt",
-	8080:  "HTTP Proxy",
-	8086:  "InfluxDB",
-	8181:  "HTTP Proxy",
-	8443:  "HTTPS Alt",
-	8888:  "HTTP Proxy",
-	9000:  "SonarQube",
-	9092:  "Kafka",
-	9200:  "Elasticsearch",
-	9300:  "Elasticsearch",
-	11211: "Memcached",
-	27017: "MongoDB",
-	27018: "MongoDB",
-	27019: "MongoDB",
-	50000: "SAP",
-	50070: "Hadoop",
+	20:    "ftp-data",
+	21:    "ftp",
+	22:    "ssh",
+	23:    "telnet",
+	25:    "smtp",
+	53:    "domain",
+	67:    "dhcps",
+	68:    "dhcpc",
+	69:    "tftp",
+	80:    "http",
+	110:   "pop3",
+	119:   "nntp",
+	123:   "ntp",
+	135:   "msrpc",
+	137:   "netbios-ns",
+	138:   "netbios-dgm",
+	139:   "netbios-ssn",
+	143:   "imap",
+	161:   "snmp",
+	162:   "snmptrap",
+	179:   "bgp",
+	194:   "irc",
+	443:   "https",
+	445:   "microsoft-ds",
+	465:   "smtps",
+	514:   "syslog",
+	515:   "printer",
+	587:   "submission",
+	631:   "ipp",
+	636:   "ldapssl",
+	993:   "imaps",
+	995:   "pop3s",
+	1080:  "socks",
+	1194:  "openvpn",
+	1433:  "ms-sql-s",
+	1434:  "ms-sql-m",
+	1521:  "oracle",
+	1723:  "pptp",
+	1883:  "mqtt",
+	1900:  "upnp",
+	2049:  "nfs",
+	2082:  "cpanel",
+	2083:  "cpanel-ssl",
+	2483:  "ttc",
+	2484:  "ttc-ssl",
+	3306:  "mysql",
+	3389:  "ms-wbt-server",
+	3690:  "svn",
+	4444:  "metasploit",
+	4848:  "appserv-http",
+	5432:  "postgresql",
+	5632:  "pcanywherestat",
+	5900:  "vnc",
+	5984:  "couchdb",
+	6379:  "redis",
+	8000:  "http-alt",
+	8080:  "http-proxy",
+	8086:  "influxdb",
+	8181:  "http-proxy",
+	8443:  "https-alt",
+	8888:  "http-proxy",
+	9000:  "sonarqube",
+	9092:  "kafka",
+	9200:  "elasticsearch",
+	9300:  "elasticsearch",
+	11211: "memcache",
+	27017: "mongod",
+	27018: "mongod",
+	27019: "mongod",
+	50000: "sap",
+	50070: "hadoop",
+}
+
+// Common SCTP Services, named as in nmap-services
+var CommonSctpServices = map[int]string{
+	80:    "http",
+	443:   "https",
+	2904:  "m2ua",
+	2905:  "m3ua",
+	2944:  "megaco-h248",
+	3565:  "m2pa",
+	3863:  "asap-sctp",
+	3868:  "diameter",
+	4739:  "ipfix",
+	5060:  "sip",
+	5061:  "sips",
+	7626:  "simco",
+	9899:  "sctp-tunneling",
+	9900:  "iua",
+	14001: "sua",
+	29118: "sgsap",
+	29168: "sbcap",
+	36412: "s1-control",
+	36422: "x2-control",
+	38412: "ng-control",
+	38422: "xn-control",
+}
+
+// IP Protocol numbers, named as in nmap-protocols
+var IPProtocols = map[int]string{
+	0:   "hopopt",
+	1:   "icmp",
+	2:   "igmp",
+	3:   "ggp",
+	4:   "ipv4",
+	5:   "st",
+	6:   "tcp",
+	8:   "egp",
+	9:   "igp",
+	17:  "udp",
+	27:  "rdp",
+	41:  "ipv6",
+	43:  "ipv6-route",
+	44:  "ipv6-frag",
+	46:  "rsvp",
+	47:  "gre",
+	50:  "esp",
+	51:  "ah",
+	58:  "ipv6-icmp",
+	59:  "ipv6-nonxt",
+	60:  "ipv6-opts",
+	88:  "eigrp",
+	89:  "ospfigp",
+	94:  "ipip",
+	97:  "etherip",
+	103: "pim",
+	108: "ipcomp",
+	112: "vrrp",
+	115: "l2tp",
+	132: "sctp",
+	135: "mobility-hdr",
+	136: "udplite",
+	137: "mpls-in-ip",
+	139: "hip",
+	140: "shim6",
+	142: "rohc",
+	143: "ethernet",
 }
 
 // Type definitions
@@ -118,6 +190,35 @@ type Arguments struct {
 	Format        string
 	ScanType      string
 	HostDiscovery bool
+	ScanDelay     time.Duration
+	PingSweep     bool
+	Zombie        string
+	SynPing       string
+	AckPing       string
+	UdpPing       string
+	EchoPing      bool
+	TimestampPing bool
+	MaskPing      bool
+	// OUI list replacing the bundled sample used to look up MAC vendors
+	OUIFile     string
+	OSDetection bool
+	Traceroute  bool
+	Versions    bool
+	// Highest rarity of the version probes sent
+	VersionIntensity int
+	// nmap-service-probes file replacing the bundled probes
+	ServiceProbes string
+	TLS           bool
+	TLSVersions   bool
+	// Run the protocol enumerations on open ports
+	Enumerate bool
+	// Domain whose zone transfer is requested from DNS servers
+	DNSDomain string
+	// Comma separated communities tried on SNMP agents
+	SNMPCommunities string
+	// Bytes read at most from banners and how long to wait for them
+	BannerLength  int
+	BannerTimeout time.Duration
 	// TODO ADD MORE OPTIONS
 	/**
 	NOTE: Options to filter by
@@ -133,16 +234,645 @@ type Port struct {
 	Port    int
 	Status  string
 	Service string
+	// Product, version and extra information found by version detection
+	Product   string `json:",omitempty"`
+	Version   string `json:",omitempty"`
+	ExtraInfo string `json:",omitempty"`
+	// Data sent by the port right after connecting, or in reply to the UDP probe
+	Banner *Banner `json:",omitempty"`
+	// Handshake and certificate chain of ports speaking TLS
+	TLS *TLSInfo `json:",omitempty"`
+	// Root page of web servers
+	HTTP *HTTPInfo `json:",omitempty"`
+	// Identification, algorithms and host keys of SSH servers
+	SSH *SSHInfo `json:",omitempty"`
+	// Dialects, signing and NTLMSSP host information of SMB servers
+	SMB *SMBInfo `json:",omitempty"`
+	// Name table of the NetBIOS name service of the host
+	NetBIOS *NetBIOSInfo `json:",omitempty"`
+	// Recursion, identification, extensions and zone transfer of DNS servers
+	DNS *DNSInfo `json:",omitempty"`
+	// System information and interfaces of SNMP agents
+	SNMP *SNMPInfo `json:",omitempty"`
+	// Version, authentication and TLS support of databases, brokers and clustered services
+	Database *DatabaseInfo `json:",omitempty"`
+	// Greeting, capabilities, STARTTLS and authentication of mail servers
+	Mail *MailInfo `json:",omitempty"`
+	// Security protocols and certificate of RDP servers
+	RDP *RDPInfo `json:",omitempty"`
+	// Protocol version and security types of VNC servers
+	VNC *VNCInfo `json:",omitempty"`
+	// Traits of the SYN/ACK received on SYN scans, only used to guess the OS
+	Fingerprint *TCPFingerprint `json:"-"`
+}
+
+type Banner struct {
+	// Printable ASCII, anything else escaped as in Go strings
+	Text string
+	// Bytes received, base64 encoded
+	Raw string
+}
+
+type TLSInfo struct {
+	Version     string
+	CipherSuite string
+	// Application protocol negotiated, e.g., h2
+	ALPN string `json:",omitempty"`
+	// Protocol versions accepted by the server, only filled when enumerated
+	SupportedVersions []string `json:",omitempty"`
+	// Chain sent by the server, leaf first
+	Certificates []Certificate
+}
+
+type HTTPInfo struct {
+	StatusCode int
+	Server     string `json:",omitempty"`
+	Title      string `json:",omitempty"`
+	// Target of redirects
+	Location string `json:",omitempty"`
+	// Shodan compatible MurmurHash3 of the favicon
+	FaviconHash int32 `json:",omitempty"`
+}
+
+type SSHInfo struct {
+	ProtocolVersion string
+	Software        string
+	Comments        string `json:",omitempty"`
+	// Algorithms offered in the KEXINIT of the server
+	KexAlgorithms     []string `json:",omitempty"`
+	HostKeyAlgorithms []string `json:",omitempty"`
+	Ciphers           []string `json:",omitempty"`
+	MACs              []string `json:",omitempty"`
+	Compression       []string `json:",omitempty"`
+	// Offered algorithms known to be broken or relying on SHA-1
+	WeakAlgorithms []string     `json:",omitempty"`
+	HostKeys       []SSHHostKey `json:",omitempty"`
+}
+
+type SSHHostKey struct {
+	Type string
+	Bits int
+	// Fingerprints as shown by OpenSSH
+	SHA256 string
+	MD5    string
+}
+
+type SMBInfo struct {
+	Dialects        []string
+	SigningEnabled  bool
+	SigningRequired bool
+	// Host information sent in the NTLMSSP challenge
+	OS            string `json:",omitempty"`
+	NetBIOSName   string `json:",omitempty"`
+	NetBIOSDomain string `json:",omitempty"`
+	DNSName       string `json:",omitempty"`
+	DNSDomain     string `json:",omitempty"`
+	DNSForest     string `json:",omitempty"`
+}
+
+type NetBIOSInfo struct {
+	Names []NetBIOSEntry
+	// Address of the adapter answering, zero on most non Windows hosts
+	MAC string `json:",omitempty"`
+}
+
+type NetBIOSEntry struct {
+	Name string
+	// Type of the name in hex, e.g., 00 for workstations and 20 for file servers
+	Suffix string
+	Group  bool
+}
+
+type DNSInfo struct {
+	// Recursive queries for names outside the zones of the server are answered
+	Recursion bool
+	// version.bind and hostname.bind CHAOS responses
+	Version  string `json:",omitempty"`
+	Hostname string `json:",omitempty"`
+	EDNS     bool
+	// UDP payload size announced in the OPT record
+	EDNSPayloadSize int `json:",omitempty"`
+	DNSSEC          bool
+	// Domain whose transfer was requested, and its records when allowed
+	Domain       string   `json:",omitempty"`
+	ZoneTransfer bool     `json:",omitempty"`
+	ZoneRecords  []string `json:",omitempty"`
+}
+
+type SNMPInfo struct {
+	// Version and community accepted, v3 when no community was
+	Version   string
+	Community string `json:",omitempty"`
+	SysDescr  string `json:",omitempty"`
+	SysName   string `json:",omitempty"`
+	// Time since the agent started, in hundredths of a second as sent
+	SysUpTimeTicks int64           `json:",omitempty"`
+	SysContact     string          `json:",omitempty"`
+	Interfaces     []SNMPInterface `json:",omitempty"`
+	// SNMPv3 engine of the agent, found without credentials
+	EngineID          string `json:",omitempty"`
+	EngineBoots       int    `json:",omitempty"`
+	EngineTimeSeconds int64  `json:",omitempty"`
+}
+
+type SNMPInterface struct {
+	Index       int
+	Description string
+	MAC         string `json:",omitempty"`
+	Status      string `json:",omitempty"`
+}
+
+type DatabaseInfo struct {
+	Product string
+	Version string `json:",omitempty"`
+	// False when commands or a passwordless default superuser are accepted
+	AuthRequired bool
+	TLS          bool
+	// Cluster or replica set name
+	ClusterName string `json:",omitempty"`
+	// Mode or error message sent by the server
+	Info string `json:",omitempty"`
+}
+
+type MailInfo struct {
+	// SMTP, POP3 or IMAP
+	Protocol     string
+	Greeting     string
+	Capabilities []string
+	// Encrypted from the start of the connection
+	ImplicitTLS bool
+	StartTLS    bool
+	// Mechanisms of the encrypted session when STARTTLS succeeded
+	AuthMechanisms []string
+	// Passwords accepted in cleartext before TLS is started
+	PlaintextAuth bool
+	// Session negotiated after STARTTLS
+	StartTLSSession *TLSInfo `json:",omitempty"`
+}
+
+type RDPInfo struct {
+	// Accepted among RDP, TLS, CredSSP, CredSSP Early User Auth and RDSTLS
+	SecurityProtocols []string
+	// Network Level Authentication enforced, neither standard RDP nor TLS is accepted
+	NLARequired bool
+	// Reasons given for the protocols refused
+	Failures []string `json:",omitempty"`
+	// Session negotiated with the first protocol wrapped in TLS
+	TLSSession *TLSInfo `json:",omitempty"`
+}
+
+type VNCInfo struct {
+	ProtocolVersion string
+	SecurityTypes   []string
+	// The None security type is offered
+	NoAuth bool
+	// Reason sent by servers refusing the connection
+	Error string `json:",omitempty"`
+}
+
+type Certificate struct {
+	Subject   string
+	Issuer    string
+	SANs      []string `json:",omitempty"`
+	NotBefore time.Time
+	NotAfter  time.Time
+	KeyType   string
+	// Key size in bits
+	KeySize    int
+	SelfSigned bool
+}
+
+type TCPFingerprint struct {
+	TTL    int
+	Window int
+	MSS    int
+	// Order of the TCP options, one letter per option (M, N, W, S, T, E)
+	Options string
+}
+
+type OSGuess struct {
+	Family string
+	// Percentage of the signature traits matched
+	Confidence int
+}
+
+type OSMatch struct {
+	Name string
+	// Percentage of the weighted fingerprint attributes matched
+	Accuracy int
+	CPE      []string
+}
+
+type Hop struct {
+	TTL     int
+	Address string
+	RTT     time.Duration
+	// Reverse DNS name of the hop
+	Hostname string `json:",omitempty"`
 }
 
 type ScanParameters struct {
 	Target  string
 	Ports   []int
 	Timeout time.Duration
+	Delay   time.Duration
+	// Zombie host and port used by idle scans
+	Zombie     string
+	ZombiePort int
+	// Send OS detection probes after the scan
+	OSDetection bool
+	// Trace the route to the host after the scan
+	Traceroute bool
+	// Probe open ports for their service version
+	VersionDetection bool
+	VersionIntensity int
+	// Inspect the TLS handshake of open ports, optionally listing every supported protocol version
+	TLSInspection bool
+	TLSVersions   bool
+	// Run the protocol enumerations matching the services found
+	Enumeration     bool
+	DNSDomain       string
+	SNMPCommunities []string
+	// Bytes read at most from banners and how long to wait for them
+	BannerLength  int
+	BannerTimeout time.Duration
+}
+
+// Command line, scan type, ports and timing of a run, exported as scan metadata
+type ScanRun struct {
+	Args string
+	// Empty on ping sweeps
+	ScanType string
+	Ports    []int
+	// Every target expanded, ping sweeps only return the hosts found up
+	Targets []string
+	Start   time.Time
+	End     time.Time
+}
+
+// Probes used to check if a host is up
+type DiscoveryParameters struct {
+	Timeout     time.Duration
+	SynPorts    []int
+	AckPorts    []int
+	UdpPorts    []int
+	Echo        bool
+	Timestamp   bool
+	AddressMask bool
+}
+
+type Host struct {
+	Address string
+	Status  string
+	// Discovery probe which found the host
+	Method string `json:",omitempty"`
+	// Time until the first discovery reply
+	Latency time.Duration `json:",omitempty"`
+	// Hardware address and vendor of hosts on the local segment
+	MAC    string `json:",omitempty"`
+	Vendor string `json:",omitempty"`
+	// OS family guessed from the SYN/ACKs of the target
+	OSGuess *OSGuess `json:",omitempty"`
+	// Best matches of the active OS detection
+	OSMatches []OSMatch `json:",omitempty"`
+	// Route to the host, hops that did not answer are left out
+	Hops  []Hop `json:",omitempty"`
+	Ports []Port
 }
 
 // Auxiliary functions
 
+// Family and confidence of an OS guess as text, empty when there is no guess
+func (g *OSGuess) Strings() (string, string) {
+	if g == nil {
+		return "", ""
+	}
+
+	return g.Family, fmt.Sprintf("%d%%", g.Confidence)
+}
+
+// Store the bytes of a banner escaped and base64 encoded, nil when nothing was received
+func NewBanner(data []byte) *Banner {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return &Banner{Text: EscapeBanner(data), Raw: base64.StdEncoding.EncodeToString(data)}
+}
+
+// Escape the bytes of a banner so it prints on a single line, keeping printable ASCII
+func EscapeBanner(data []byte) string {
+	var escaped strings.Builder
+
+	for _, b := range data {
+		switch {
+		case b == '\\':
+			escaped.WriteString(`\\`)
+		case b == '\r':
+			escaped.WriteString(`\r`)
+		case b == '\n':
+			escaped.WriteString(`\n`)
+		case b == '\t':
+			escaped.WriteString(`\t`)
+		case b >= 0x20 && b < 0x7f:
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "\\x%02x", b)
+		}
+	}
+
+	return escaped.String()
+}
+
+// Negotiated version, cipher suite, ALPN and supported versions of a TLS handshake as text
+func (t *TLSInfo) Summary() string {
+	summary := fmt.Sprintf("TLS: %s, Cipher: %s", t.Version, t.CipherSuite)
+
+	if t.ALPN != "" {
+		summary += fmt.Sprintf(", ALPN: %s", t.ALPN)
+	}
+
+	if len(t.SupportedVersions) > 0 {
+		summary += fmt.Sprintf(", Supported: %s", strings.Join(t.SupportedVersions, " "))
+	}
+
+	return summary
+}
+
+// Subject, issuer, SANs, validity and key of a certificate as text
+func (c Certificate) Summary() string {
+	summary := fmt.Sprintf("Certificate: %s, Issuer: %s, SANs: %s, Valid: %s to %s, Key: %s %d", c.Subject, c.Issuer, strings.Join(c.SANs, " "), c.NotBefore.Format(time.DateOnly), c.NotAfter.Format(time.DateOnly), c.KeyType, c.KeySize)
+
+	if c.SelfSigned {
+		summary += ", Self-Signed"
+	}
+
+	return summary
+}
+
+// Status, server, title, redirect and favicon hash of a web server as text
+func (h *HTTPInfo) Summary() string {
+	summary := fmt.Sprintf("HTTP: %d", h.StatusCode)
+
+	if h.Server != "" {
+		summary += fmt.Sprintf(", Server: %s", h.Server)
+	}
+
+	if h.Title != "" {
+		summary += fmt.Sprintf(", Title: %s", h.Title)
+	}
+
+	if h.Location != "" {
+		summary += fmt.Sprintf(", Location: %s", h.Location)
+	}
+
+	if h.FaviconHash != 0 {
+		summary += fmt.Sprintf(", Favicon: %d", h.FaviconHash)
+	}
+
+	return summary
+}
+
+// Version and algorithms of an SSH server as text
+func (s *SSHInfo) Summary() string {
+	summary := fmt.Sprintf("SSH: %s, Software: %s", s.ProtocolVersion, s.Software)
+
+	if s.Comments != "" {
+		summary += fmt.Sprintf(" %s", s.Comments)
+	}
+
+	for _, list := range []struct {
+		name       string
+		algorithms []string
+	}{{"Kex", s.KexAlgorithms}, {"Host Keys", s.HostKeyAlgorithms}, {"Ciphers", s.Ciphers}, {"MACs", s.MACs}, {"Weak", s.WeakAlgorithms}} {
+		if len(list.algorithms) > 0 {
+			summary += fmt.Sprintf(", %s: %s", list.name, strings.Join(list.algorithms, " "))
+		}
+	}
+
+	return summary
+}
+
+// Dialects, signing and host information of an SMB server as text
+func (s *SMBInfo) Summary() string {
+	summary := fmt.Sprintf("SMB: %s, Signing Enabled: %t, Signing Required: %t", strings.Join(s.Dialects, " "), s.SigningEnabled, s.SigningRequired)
+
+	for _, field := range []struct {
+		name  string
+		value string
+	}{{"OS", s.OS}, {"NetBIOS Name", s.NetBIOSName}, {"NetBIOS Domain", s.NetBIOSDomain}, {"DNS Name", s.DNSName}, {"DNS Domain", s.DNSDomain}, {"Forest", s.DNSForest}} {
+		if field.value != "" {
+			summary += fmt.Sprintf(", %s: %s", field.name, field.value)
+		}
+	}
+
+	return summary
+}
+
+// Name table and MAC address of a NetBIOS name service as text
+func (n *NetBIOSInfo) Summary() string {
+	var names []string
+	for _, entry := range n.Names {
+		name := fmt.Sprintf("%s<%s>", entry.Name, entry.Suffix)
+		if entry.Group {
+			name += " (group)"
+		}
+		names = append(names, name)
+	}
+
+	summary := fmt.Sprintf("NetBIOS: %s", strings.Join(names, " "))
+	if n.MAC != "" {
+		summary += fmt.Sprintf(", MAC: %s", n.MAC)
+	}
+
+	return summary
+}
+
+// Recursion, identification, extensions and zone transfer of a DNS server as text
+func (d *DNSInfo) Summary() string {
+	summary := fmt.Sprintf("DNS: Recursion: %t, EDNS: %t, DNSSEC: %t", d.Recursion, d.EDNS, d.DNSSEC)
+
+	if d.Version != "" {
+		summary += fmt.Sprintf(", Version: %s", d.Version)
+	}
+
+	if d.Hostname != "" {
+		summary += fmt.Sprintf(", Hostname: %s", d.Hostname)
+	}
+
+	if d.Domain != "" {
+		summary += fmt.Sprintf(", Zone Transfer of %s: %t", d.Domain, d.ZoneTransfer)
+		if d.ZoneTransfer {
+			summary += fmt.Sprintf(" (%d records)", len(d.ZoneRecords))
+		}
+	}
+
+	return summary
+}
+
+// System information and engine of an SNMP agent as text
+func (s *SNMPInfo) Summary() string {
+	summary := fmt.Sprintf("SNMP: %s", s.Version)
+
+	for _, field := range []struct {
+		name  string
+		value string
+	}{{"Community", s.Community}, {"Description", s.SysDescr}, {"Name", s.SysName}, {"Contact", s.SysContact}} {
+		if field.value != "" {
+			summary += fmt.Sprintf(", %s: %s", field.name, field.value)
+		}
+	}
+
+	if s.SysUpTimeTicks != 0 {
+		summary += fmt.Sprintf(", Uptime: %s", time.Duration(s.SysUpTimeTicks)*10*time.Millisecond)
+	}
+
+	if s.EngineID != "" {
+		summary += fmt.Sprintf(", Engine ID: %s, Engine Boots: %d, Engine Time: %s", s.EngineID, s.EngineBoots, time.Duration(s.EngineTimeSeconds)*time.Second)
+	}
+
+	return summary
+}
+
+// Product, version, authentication and TLS support of a database as text
+func (d *DatabaseInfo) Summary() string {
+	summary := strings.TrimSpace(fmt.Sprintf("Service: %s %s", d.Product, d.Version))
+	summary += fmt.Sprintf(", Auth Required: %t, TLS: %t", d.AuthRequired, d.TLS)
+
+	if d.ClusterName != "" {
+		summary += fmt.Sprintf(", Cluster: %s", d.ClusterName)
+	}
+
+	if d.Info != "" {
+		summary += fmt.Sprintf(", Info: %s", d.Info)
+	}
+
+	return summary
+}
+
+// Protocol, greeting, TLS and authentication of a mail server as text
+func (m *MailInfo) Summary() string {
+	summary := fmt.Sprintf("Mail: %s, Greeting: %s", m.Protocol, m.Greeting)
+
+	if len(m.Capabilities) > 0 {
+		summary += fmt.Sprintf(", Capabilities: %s", strings.Join(m.Capabilities, " | "))
+	}
+
+	summary += fmt.Sprintf(", Implicit TLS: %t, STARTTLS: %t", m.ImplicitTLS, m.StartTLS)
+
+	if len(m.AuthMechanisms) > 0 {
+		summary += fmt.Sprintf(", Auth: %s", strings.Join(m.AuthMechanisms, " "))
+	}
+
+	if m.PlaintextAuth {
+		summary += ", Plaintext Auth Without TLS"
+	}
+
+	return summary
+}
+
+// Security protocols and NLA enforcement of an RDP server as text
+func (r *RDPInfo) Summary() string {
+	summary := fmt.Sprintf("RDP: %s, NLA Required: %t", strings.Join(r.SecurityProtocols, ", "), r.NLARequired)
+
+	if len(r.Failures) > 0 {
+		summary += fmt.Sprintf(", Refused: %s", strings.Join(r.Failures, "; "))
+	}
+
+	return summary
+}
+
+// Protocol version and security types of a VNC server as text
+func (v *VNCInfo) Summary() string {
+	summary := fmt.Sprintf("VNC: RFB %s", v.ProtocolVersion)
+
+	if len(v.SecurityTypes) > 0 {
+		summary += fmt.Sprintf(", Security Types: %s", strings.Join(v.SecurityTypes, ", "))
+	}
+
+	if v.NoAuth {
+		summary += ", No Authentication"
+	}
+
+	if v.Error != "" {
+		summary += fmt.Sprintf(", Error: %s", v.Error)
+	}
+
+	return summary
+}
+
+// Details found by the protocol inspections of a port, one line each
+func (p Port) Details() []string {
+	var details []string
+
+	if p.TLS != nil {
+		details = append(details, p.TLS.Summary())
+		for _, cert := range p.TLS.Certificates {
+			details = append(details, cert.Summary())
+		}
+	}
+
+	if p.HTTP != nil {
+		details = append(details, p.HTTP.Summary())
+	}
+
+	if p.SSH != nil {
+		details = append(details, p.SSH.Summary())
+		for _, key := range p.SSH.HostKeys {
+			details = append(details, fmt.Sprintf("Host Key: %s %d %s %s", key.Type, key.Bits, key.SHA256, key.MD5))
+		}
+	}
+
+	if p.SMB != nil {
+		details = append(details, p.SMB.Summary())
+	}
+
+	if p.NetBIOS != nil {
+		details = append(details, p.NetBIOS.Summary())
+	}
+
+	if p.DNS != nil {
+		details = append(details, p.DNS.Summary())
+	}
+
+	if p.SNMP != nil {
+		details = append(details, p.SNMP.Summary())
+		for _, iface := range p.SNMP.Interfaces {
+			details = append(details, strings.TrimSpace(fmt.Sprintf("Interface: %d %s %s %s", iface.Index, iface.Description, iface.MAC, iface.Status)))
+		}
+	}
+
+	if p.Database != nil {
+		details = append(details, p.Database.Summary())
+	}
+
+	if p.Mail != nil {
+		details = append(details, p.Mail.Summary())
+		if p.Mail.StartTLSSession != nil {
+			details = append(details, "STARTTLS "+p.Mail.StartTLSSession.Summary())
+			for _, cert := range p.Mail.StartTLSSession.Certificates {
+				details = append(details, cert.Summary())
+			}
+		}
+	}
+
+	if p.RDP != nil {
+		details = append(details, p.RDP.Summary())
+		if p.RDP.TLSSession != nil {
+			details = append(details, "RDP "+p.RDP.TLSSession.Summary())
+			for _, cert := range p.RDP.TLSSession.Certificates {
+				details = append(details, cert.Summary())
+			}
+		}
+	}
+
+	if p.VNC != nil {
+		details = append(details, p.VNC.Summary())
+	}
+
+	return details
+}
+
 // Print error in red
 func PrintError(msg string) error {
 	return fmt.Errorf("%s%s%s", Red, msg, Reset)
@@ -153,36 +883,104 @@ func PrintSuccess(msg string) {
 	fmt.Printf("%s%s%s\n", Green, msg, Reset)
 }
 
-func exportToTxt(results []Port, file *os.File) error {
+// Print warning in yellow
+func PrintWarning(msg string) {
+	fmt.Printf("%s%s%s\n", Yellow, msg, Reset)
+}
+
+func exportToTxt(hosts []Host, file *os.File) error {
 	// Dump results
-	for _, result := range results {
-		line := fmt.Sprintf("Port: %d, Status: %s, Service: %s\n", result.Port, result.Status, result.Service)
+	for _, host := range hosts {
+		osFamily, osConfidence := host.OSGuess.Strings()
+		line := fmt.Sprintf("Host: %s, Status: %s, Method: %s, Latency: %s, MAC: %s, Vendor: %s, OS: %s, OS Confidence: %s\n", host.Address, host.Status, host.Method, host.Latency, host.MAC, host.Vendor, osFamily, osConfidence)
 		if _, err := file.WriteString(line); err != nil {
 			return fmt.Errorf("could not write to file: %v", err)
 		}
+
+		for _, match := range host.OSMatches {
+			line := fmt.Sprintf("OS Match: %s, Accuracy: %d%%, CPE: %s\n", match.Name, match.Accuracy, strings.Join(match.CPE, " "))
+			if _, err := file.WriteString(line); err != nil {
+				return fmt.Errorf("could not write to file: %v", err)
+			}
+		}
+
+		for _, hop := range host.Hops {
+			line := fmt.Sprintf("Hop: %d, Address: %s, RTT: %s, Hostname: %s\n", hop.TTL, hop.Address, hop.RTT, hop.Hostname)
+			if _, err := file.WriteString(line); err != nil {
+				return fmt.Errorf("could not write to file: %v", err)
+			}
+		}
+
+		for _, result := range host.Ports {
+			line := fmt.Sprintf("Port: %d, Status: %s, Service: %s, Product: %s, Version: %s, Extra Info: %s\n", result.Port, result.Status, result.Service, result.Product, result.Version, result.ExtraInfo)
+			if _, err := file.WriteString(line); err != nil {
+				return fmt.Errorf("could not write to file: %v", err)
+			}
+
+			if result.Banner != nil {
+				if _, err := file.WriteString(fmt.Sprintf("  Banner: %s\n", result.Banner.Text)); err != nil {
+					return fmt.Errorf("could not write to file: %v", err)
+				}
+			}
+
+			for _, detail := range result.Details() {
+				if _, err := file.WriteString(fmt.Sprintf("  %s\n", detail)); err != nil {
+					return fmt.Errorf("could not write to file: %v", err)
+				}
+			}
+		}
 	}
 
 	PrintSuccess("[!] Results successfully exported to .txt file")
 	return nil
 }
 
-func exportToCsv(results []Port, file *os.File) error {
+func exportToCsv(hosts []Host, file *os.File) error {
 
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
 
 	// Write header
-	header := []string{"Port", "Status", "Service"}
+	header := []string{"Host", "Host Status", "Method", "Latency", "MAC", "Vendor", "OS", "OS Confidence", "OS Matches", "CPE", "Traceroute", "Port", "Status", "Service", "Product", "Version", "Extra Info", "Banner", "Details"}
 	if err := writer.Write(header); err != nil {
 		return PrintError(fmt.Sprintf("[ERROR] could not write header to file: %v", err))
 	}
 
 	// Dump results
-	for _, result := range results {
-		record := []string{fmt.Sprintf("%d", result.Port), result.Status, result.Service}
+	for _, host := range hosts {
+		osFamily, osConfidence := host.OSGuess.Strings()
+		var osMatches, cpes []string
+		for _, match := range host.OSMatches {
+			osMatches = append(osMatches, fmt.Sprintf("%s (%d%%)", match.Name, match.Accuracy))
+			cpes = append(cpes, match.CPE...)
+		}
+
+		// Hops as TTL, address, RTT and hostname
+		var hops []string
+		for _, hop := range host.Hops {
+			hops = append(hops, strings.TrimSpace(fmt.Sprintf("%d %s %s %s", hop.TTL, hop.Address, hop.RTT, hop.Hostname)))
+		}
+
+		hostRecord := []string{host.Address, host.Status, host.Method, host.Latency.String(), host.MAC, host.Vendor, osFamily, osConfidence, strings.Join(osMatches, "; "), strings.Join(cpes, " "), strings.Join(hops, "; ")}
+
+		// Hosts without ports still get a row
+		if len(host.Ports) == 0 {
+			if err := writer.Write(append(hostRecord, "", "", "", "", "", "", "", "")); err != nil {
+				return PrintError(fmt.Sprintf("[ERROR] could not write record to file: %v", err))
+			}
+		}
+
+		for _, result := range host.Ports {
+			var banner string
+			if result.Banner != nil {
+				banner = result.Banner.Text
+			}
+
+			record := append(hostRecord, fmt.Sprintf("%d", result.Port), result.Status, result.Service, result.Product, result.Version, result.ExtraInfo, banner, strings.Join(result.Details(), "; "))
 
-		if err := writer.Write(record); err != nil {
-			return PrintError(fmt.Sprintf("[ERROR] could not write record to file: %v", err))
+			if err := writer.Write(record); err != nil {
+				return PrintError(fmt.Sprintf("[ERROR] could not write record to file: %v", err))
+			}
 		}
 	}
 
@@ -190,10 +988,10 @@ func exportToCsv(results []Port, file *os.File) error {
 	return nil
 }
 
-func exportToJson(results []Port, file *os.File) error {
+func exportToJson(hosts []Host, file *os.File) error {
 
 	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(results); err != nil {
+	if err := encoder.Encode(hosts); err != nil {
 		return PrintError(fmt.Sprintf("[ERROR] could not encode results to JSON: %v", err))
 	}
 
@@ -202,7 +1000,7 @@ func exportToJson(results []Port, file *os.File) error {
 }
 
 // Export to file
-func ExportResults(results []Port, file string, format string) error {
+func ExportResults(hosts []Host, run ScanRun, file string, format string) error {
 
 	var fileName string = fmt.Sprintf("%s.%s", file, format)
 
@@ -217,11 +1015,13 @@ func ExportResults(results []Port, file string, format string) error {
 	// Handle formats to export to
 	switch format {
 	case "txt":
-		return exportToTxt(results, f)
+		return exportToTxt(hosts, f)
 	case "csv":
-		return exportToCsv(results, f)
+		return exportToCsv(hosts, f)
 	case "json":
-		return exportToJson(results, f)
+		return exportToJson(hosts, f)
+	case "xml":
+		return exportToXml(hosts, run, f)
 	}
 
 	return nil
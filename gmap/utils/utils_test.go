@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestEscapeBanner(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"SSH-2.0-OpenSSH_9.6", "SSH-2.0-OpenSSH_9.6"},
+		{"220 FTP ready\r\n", `220 FTP ready\r\n`},
+		{"a\tb", `a\tb`},
+		{`C:\temp`, `C:\\temp`},
+		{"\x00\x01\x1b[0m", `\x00\x01\x1b[0m`},
+		{"caf\xc3\xa9 \x7f\xff", `caf\xc3\xa9 \x7f\xff`},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := EscapeBanner([]byte(test.data)); got != test.want {
+			t.Errorf("EscapeBanner(%q) = %q, want %q", test.data, got, test.want)
+		}
+	}
+}
+
+func TestNewBanner(t *testing.T) {
+	if banner := NewBanner(nil); banner != nil {
+		t.Errorf("empty banner is %+v, want nil", banner)
+	}
+
+	data := []byte("+OK\r\n\xff")
+	banner := NewBanner(data)
+	if banner.Text != `+OK\r\n\xff` {
+		t.Errorf("banner text is %q", banner.Text)
+	}
+
+	if raw, err := base64.StdEncoding.DecodeString(banner.Raw); err != nil || string(raw) != string(data) {
+		t.Errorf("banner raw is %q, want the base64 of %q", banner.Raw, data)
+	}
+}